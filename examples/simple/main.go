@@ -23,12 +23,26 @@ func (m *TimerModule) Name() string {
 	return "timer"
 }
 
+// Metadata describes the module in the modules command and help
+func (m *TimerModule) Metadata() shellapi.ModuleMetadata {
+	return shellapi.ModuleMetadata{
+		Version:     "1.0.0",
+		Description: "Time since the shell started",
+		Author:      "gocmd2 examples",
+	}
+}
+
 // Initialize is called when the module is registered
 func (m *TimerModule) Initialize(s shellapi.ShellAPI) {
 	m.shell = s
 
-	// Store the start time in shared state
-	m.shell.SetState("start_time", time.Now())
+	// Store the start time in shared state, read-only to other modules
+	if err := m.shell.ProtectState(m.Name(), "start_time"); err != nil {
+		fmt.Fprintf(m.shell.Stderr(), "Error protecting start time: %v\n", err)
+	}
+	if err := m.shell.SetOwnedState(m.Name(), "start_time", time.Now()); err != nil {
+		fmt.Fprintf(m.shell.Stderr(), "Error storing start time: %v\n", err)
+	}
 }
 
 func (m *TimerModule) GetCommands() []*cobra.Command {
@@ -40,16 +54,15 @@ func (m *TimerModule) GetCommands() []*cobra.Command {
 		Short: "Show elapsed time since shell started",
 		Run: func(cmd *cobra.Command, args []string) {
 			// Get the start time from shared state
-			startTimeValue, ok := m.shell.GetState("start_time")
+			startTime, ok := shellapi.GetStateAs[time.Time](m.shell, "start_time")
 			if !ok {
-				fmt.Println("Start time not found in state")
+				fmt.Fprintln(m.shell.Stdout(), "Start time not found in state")
 				return
 			}
 
-			startTime := startTimeValue.(time.Time)
 			elapsed := time.Since(startTime)
 
-			fmt.Printf("Shell has been running for %s\n", elapsed.Round(time.Second))
+			fmt.Fprintf(m.shell.Stdout(), "Shell has been running for %s\n", elapsed.Round(time.Second))
 
 			// Update the prompt to show running time
 			m.shell.SetPrompt(fmt.Sprintf("(%s)", elapsed.Round(time.Second)))
@@ -61,10 +74,13 @@ func (m *TimerModule) GetCommands() []*cobra.Command {
 	resetCmd := &cobra.Command{
 		Use:   "reset",
 		Short: "Reset the timer",
-		Run: func(cmd *cobra.Command, args []string) {
-			m.shell.SetState("start_time", time.Now())
-			fmt.Println("Timer reset")
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.SetOwnedState(m.Name(), "start_time", time.Now()); err != nil {
+				return err
+			}
+			fmt.Fprintln(m.shell.Stdout(), "Timer reset")
 			m.shell.SetPrompt(">")
+			return nil
 		},
 	}
 	commands = append(commands, resetCmd)
@@ -83,7 +99,10 @@ func main() {
 
 	// Register our custom timer module
 	timerModule := NewTimerModule()
-	sh.RegisterModule(timerModule)
+	if err := sh.RegisterModule(timerModule); err != nil {
+		fmt.Printf("Error registering module: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Set exit handler
 	sh.OnExit(func() {
@@ -91,6 +110,6 @@ func main() {
 		// Example of cleanup code that would be run on exit
 	})
 
-	// Run the shell
-	sh.Run()
+	// Run the shell, or the command given with -c
+	sh.RunArgs(os.Args[1:])
 }
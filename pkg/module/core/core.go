@@ -1,9 +1,15 @@
 package core
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"os"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
@@ -24,77 +30,819 @@ func (m *Module) Name() string {
 	return "core"
 }
 
+// Metadata describes the core module
+func (m *Module) Metadata() shellapi.ModuleMetadata {
+	return shellapi.ModuleMetadata{Description: "Built-in shell commands"}
+}
+
 // GetCommands returns all commands provided by this module
 func (m *Module) GetCommands() []*cobra.Command {
 	commands := []*cobra.Command{}
 
 	// Exit command
 	exitCmd := &cobra.Command{
-		Use:   "exit",
-		Short: "Exit the shell",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Goodbye!")
-			os.Exit(0)
+		Use:   "exit [status]",
+		Short: "Exit the shell, with the last command's status by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			status := m.shell.LastStatus()
+			if len(args) == 1 {
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return fmt.Errorf("invalid exit status: %s", args[0])
+				}
+				status = n
+			}
+			if m.shell.Interactive() {
+				fmt.Fprintln(m.shell.Stdout(), "Goodbye!")
+			}
+			m.shell.Exit(status)
+			return nil
 		},
 	}
 	commands = append(commands, exitCmd)
 
+	// Clear command - clear the terminal, optionally showing the banner again
+	var clearBanner bool
+	clearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Clear the screen",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			m.shell.ClearScreen()
+			if clearBanner {
+				m.shell.ReprintBanner()
+			}
+		},
+	}
+	clearCmd.Flags().BoolVarP(&clearBanner, "banner", "b", false, "Print the banner again after clearing")
+	commands = append(commands, clearCmd)
+
 	// Modules command - list all modules
 	modulesCmd := &cobra.Command{
 		Use:   "modules",
 		Short: "List available modules",
 		Run: func(cmd *cobra.Command, args []string) {
 			modules := m.shell.GetModules()
-			fmt.Println("Available modules:")
+			fmt.Fprintln(m.shell.Stdout(), "Available modules:")
+			rows := make([][]string, 0, len(modules))
 			for _, name := range modules {
 				enabled := m.shell.IsModuleEnabled(name)
-				status := "enabled"
+				status := "[enabled]"
 				if !enabled {
-					status = "disabled"
+					status = "[disabled]"
 				}
-				fmt.Printf("  %-15s [%s]\n", name, status)
+				meta, _ := m.shell.GetModuleMetadata(name)
+				rows = append(rows, []string{name, status, meta.Version, meta.Author, meta.Description})
 			}
+			m.shell.Table([]string{"MODULE", "STATUS", "VERSION", "AUTHOR", "DESCRIPTION"}, rows)
 		},
 	}
 	commands = append(commands, modulesCmd)
 
 	// Enable module command
 	enableCmd := &cobra.Command{
-		Use:   "enable [module]",
-		Short: "Enable a module",
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		Use:               "enable [module]",
+		Short:             "Enable a module",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: m.completeModules(false),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			moduleName := args[0]
 			err := m.shell.EnableModule(moduleName)
 			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-				return
+				return err
 			}
-			fmt.Printf("Module '%s' enabled\n", moduleName)
+			fmt.Fprintf(m.shell.Stdout(), "Module '%s' enabled\n", moduleName)
+			return nil
 		},
 	}
 	commands = append(commands, enableCmd)
 
 	// Disable module command
 	disableCmd := &cobra.Command{
-		Use:   "disable [module]",
-		Short: "Disable a module",
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
+		Use:               "disable [module]",
+		Short:             "Disable a module",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: m.completeModules(true),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			moduleName := args[0]
 			err := m.shell.DisableModule(moduleName)
 			if err != nil {
-				fmt.Printf("Error: %v\n", err)
-				return
+				return err
 			}
-			fmt.Printf("Module '%s' disabled\n", moduleName)
+			fmt.Fprintf(m.shell.Stdout(), "Module '%s' disabled\n", moduleName)
+			return nil
 		},
 	}
 	commands = append(commands, disableCmd)
 
+	// History command - list, re-run and clear past commands
+	historyCmd := &cobra.Command{
+		Use:         "history",
+		Short:       "List previously entered commands",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{shellapi.AnnotationPager: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			for i, record := range m.shell.HistoryRecords() {
+				// Entries from text history files carry only the line, and
+				// the running command has no duration or status yet
+				when, took, status := "", "", ""
+				if !record.Time.IsZero() {
+					when = record.Time.Format("2006-01-02 15:04:05")
+				}
+				if record.Duration > 0 {
+					took = record.Duration.Round(time.Millisecond).String()
+					if record.Duration < time.Millisecond {
+						took = record.Duration.Round(time.Microsecond).String()
+					}
+					status = strconv.Itoa(record.ExitStatus)
+				}
+				fmt.Fprintf(m.shell.Stdout(), "%5d  %-19s  %9s  %3s  %s\n", i+1, when, took, status, record.Line)
+			}
+		},
+	}
+	historyCmd.AddCommand(&cobra.Command{
+		Use:   "run [number]",
+		Short: "Run a command from the history again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			history := m.shell.History()
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 || n > len(history) {
+				return fmt.Errorf("no history entry %s", args[0])
+			}
+			entry := history[n-1]
+			if fields := strings.Fields(entry); len(fields) >= 2 && fields[0] == "history" && fields[1] == "run" {
+				return fmt.Errorf("entry %d re-runs history itself", n)
+			}
+			fmt.Fprintln(m.shell.Stdout(), entry)
+			return m.shell.ExecuteCommandContext(cmd.Context(), entry)
+		},
+	})
+	historyCmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Forget all previously entered commands",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.ClearHistory(); err != nil {
+				return err
+			}
+			fmt.Fprintln(m.shell.Stdout(), "History cleared")
+			return nil
+		},
+	})
+	commands = append(commands, historyCmd)
+
+	// Cache command - invalidate cached command results
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage cached command results",
+	}
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Drop all cached command results",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			m.shell.ClearCache()
+			fmt.Fprintln(m.shell.Stdout(), "Cache cleared")
+		},
+	})
+	commands = append(commands, cacheCmd)
+
+	// Set command - view and change runtime settings
+	setCmd := &cobra.Command{
+		Use:   "set [setting] [value]",
+		Short: "View or change shell settings",
+		Args:  cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch len(args) {
+			case 0:
+				fmt.Fprintln(m.shell.Stdout(), "Settings:")
+				for _, setting := range m.shell.GetSettings() {
+					fmt.Fprintf(m.shell.Stdout(), "  %-20s %-10s %s\n", setting.Name, setting.Value, setting.Description)
+				}
+			case 1:
+				value, ok := m.shell.GetSetting(args[0])
+				if !ok {
+					return fmt.Errorf("unknown setting: %s", args[0])
+				}
+				fmt.Fprintf(m.shell.Stdout(), "%s = %s\n", args[0], value)
+			default:
+				err := m.shell.SetSetting(args[0], args[1])
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(m.shell.Stdout(), "%s = %s\n", args[0], args[1])
+			}
+			return nil
+		},
+	}
+	commands = append(commands, setCmd)
+
+	// Transaction commands - group changes and roll them back on abort
+	txnCmd := &cobra.Command{
+		Use:   "txn",
+		Short: "Group commands into a transaction",
+		Run: func(cmd *cobra.Command, args []string) {
+			if m.shell.InTransaction() {
+				fmt.Fprintln(m.shell.Stdout(), "Transaction in progress")
+			} else {
+				fmt.Fprintln(m.shell.Stdout(), "No transaction in progress")
+			}
+		},
+	}
+	txnCmd.AddCommand(&cobra.Command{
+		Use:   "begin",
+		Short: "Start a transaction",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.BeginTransaction(); err != nil {
+				return err
+			}
+			fmt.Fprintln(m.shell.Stdout(), "Transaction started")
+			return nil
+		},
+	})
+	txnCmd.AddCommand(&cobra.Command{
+		Use:   "commit",
+		Short: "Keep the changes made in the transaction",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.CommitTransaction(); err != nil {
+				return err
+			}
+			fmt.Fprintln(m.shell.Stdout(), "Transaction committed")
+			return nil
+		},
+	})
+	txnCmd.AddCommand(&cobra.Command{
+		Use:   "abort",
+		Short: "Roll back the changes made in the transaction",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.AbortTransaction(); err != nil {
+				return err
+			}
+			fmt.Fprintln(m.shell.Stdout(), "Transaction aborted")
+			return nil
+		},
+	})
+	commands = append(commands, txnCmd)
+
+	// Doctor command - run module health checks
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Run module health checks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results := m.shell.RunHealthChecks()
+			if len(results) == 0 {
+				fmt.Fprintln(m.shell.Stdout(), "No health checks registered")
+				return nil
+			}
+
+			failed := 0
+			rows := make([][]string, 0, len(results))
+			for _, result := range results {
+				if result.Err != nil {
+					failed++
+					rows = append(rows, []string{result.Name, "FAIL", result.Err.Error()})
+				} else {
+					rows = append(rows, []string{result.Name, "OK"})
+				}
+			}
+			m.shell.Table([]string{"CHECK", "STATUS", ""}, rows)
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d health checks failed", failed, len(results))
+			}
+			fmt.Fprintf(m.shell.Stdout(), "\nAll %d health checks passed\n", len(results))
+			return nil
+		},
+	}
+	commands = append(commands, doctorCmd)
+
+	// Status command - show which modules are degraded
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the health of each module",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			degraded := 0
+			rows := [][]string{}
+			for _, health := range m.shell.ModuleHealth() {
+				switch {
+				case !health.Enabled:
+					rows = append(rows, []string{health.Name, "DISABLED", ""})
+				case !health.Checked:
+					rows = append(rows, []string{health.Name, "-", "no health check"})
+				case health.Err != nil:
+					degraded++
+					rows = append(rows, []string{health.Name, "DEGRADED", health.Err.Error()})
+				default:
+					rows = append(rows, []string{health.Name, "OK", ""})
+				}
+			}
+			m.shell.Table([]string{"MODULE", "STATUS", ""}, rows)
+
+			if degraded > 0 {
+				return fmt.Errorf("%d of %d modules degraded", degraded, len(rows))
+			}
+			return nil
+		},
+	}
+	commands = append(commands, statusCmd)
+
+	// Feature command - list and toggle feature flags
+	featureCmd := &cobra.Command{
+		Use:   "feature",
+		Short: "List or toggle feature flags",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			features := m.shell.Features()
+			if len(features) == 0 {
+				fmt.Fprintln(m.shell.Stdout(), "No feature flags")
+				return
+			}
+			for _, feature := range features {
+				state := "off"
+				if feature.Enabled {
+					state = "on"
+				}
+				line := fmt.Sprintf("  %-30s %-4s %s", feature.Name, state, feature.Description)
+				fmt.Fprintln(m.shell.Stdout(), strings.TrimRight(line, " "))
+			}
+		},
+	}
+	featureCmd.AddCommand(&cobra.Command{
+		Use:               "enable [name]",
+		Short:             "Turn a feature flag on",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: m.completeFeatures(false),
+		Run: func(cmd *cobra.Command, args []string) {
+			m.shell.SetFeature(args[0], true)
+			fmt.Fprintf(m.shell.Stdout(), "Feature %s enabled\n", args[0])
+		},
+	})
+	featureCmd.AddCommand(&cobra.Command{
+		Use:               "disable [name]",
+		Short:             "Turn a feature flag off",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: m.completeFeatures(true),
+		Run: func(cmd *cobra.Command, args []string) {
+			m.shell.SetFeature(args[0], false)
+			fmt.Fprintf(m.shell.Stdout(), "Feature %s disabled\n", args[0])
+		},
+	})
+	commands = append(commands, featureCmd)
+
+	// Profile commands - move settings and state between machines
+	profileCmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Export or import the shell profile, or switch module profiles",
+	}
+	profileCmd.AddCommand(&cobra.Command{
+		Use:   "export [file]",
+		Short: "Save settings and state to a profile file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.ExportProfile(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(m.shell.Stdout(), "Profile exported to %s\n", args[0])
+			return nil
+		},
+	})
+	profileCmd.AddCommand(&cobra.Command{
+		Use:   "import [file]",
+		Short: "Load settings and state from a profile file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.ImportProfile(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(m.shell.Stdout(), "Profile imported from %s\n", args[0])
+			return nil
+		},
+	})
+	profileCmd.AddCommand(&cobra.Command{
+		Use:   "use [group]",
+		Short: "Enable only the modules of a module group",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return m.shell.ModuleGroups(), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.UseModuleProfile(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(m.shell.Stdout(), "Using module profile '%s'\n", args[0])
+			return nil
+		},
+	})
+	profileCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List module groups, marking the active one",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			groups := m.shell.ModuleGroups()
+			if len(groups) == 0 {
+				fmt.Fprintln(m.shell.Stdout(), "No module groups defined")
+				return
+			}
+			for _, name := range groups {
+				marker := " "
+				if name == m.shell.ActiveModuleProfile() {
+					marker = "*"
+				}
+				fmt.Fprintf(m.shell.Stdout(), "%s %s\n", marker, name)
+			}
+		},
+	})
+	commands = append(commands, profileCmd)
+
+	// State commands - inspect and change shared state while debugging
+	stateKeyArg := map[string]string{shellapi.AnnotationStateKeyArgs: "0"}
+	stateCmd := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect or change shared state",
+	}
+	stateCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List state keys with their types, owners and values",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			keys := m.shell.ListStateKeys()
+			if len(keys) == 0 {
+				fmt.Fprintln(m.shell.Stdout(), "No state")
+				return
+			}
+			rows := make([][]string, 0, len(keys))
+			for _, key := range keys {
+				if value, ok := m.shell.GetState(key); ok {
+					owner, _ := m.shell.StateOwner(key)
+					rows = append(rows, []string{key, fmt.Sprintf("%T", value), owner, formatStateValue(value, false)})
+				}
+			}
+			m.shell.Table([]string{"KEY", "TYPE", "OWNER", "VALUE"}, rows)
+		},
+	})
+	stateCmd.AddCommand(&cobra.Command{
+		Use:   "stats",
+		Short: "Show how much memory state keys hold, largest first",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			stats := m.shell.StateStats()
+			rows := make([][]string, 0, len(stats.Entries))
+			for _, entry := range stats.Entries {
+				rows = append(rows, []string{entry.Key, formatBytes(entry.Bytes), entry.Modified.Format("2006-01-02 15:04:05")})
+			}
+			m.shell.Table([]string{"KEY", "SIZE", "MODIFIED"}, rows)
+			fmt.Fprintf(m.shell.Stdout(), "\n%d keys, about %s\n", stats.Keys, formatBytes(stats.Bytes))
+		},
+	})
+	stateCmd.AddCommand(&cobra.Command{
+		Use:         "get [key]",
+		Short:       "Show the value of a state key",
+		Args:        cobra.ExactArgs(1),
+		Annotations: stateKeyArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value, ok := m.shell.GetState(args[0])
+			if !ok {
+				return fmt.Errorf("state key not found: %s", args[0])
+			}
+			fmt.Fprintln(m.shell.Stdout(), formatStateValue(value, true))
+			return nil
+		},
+	})
+	stateCmd.AddCommand(&cobra.Command{
+		Use:   "set [key] [value]",
+		Short: "Change the value of a state key",
+		Long: "Change the value of a state key. The value is decoded as JSON into the\n" +
+			"type the key already holds; new keys take JSON values, or the text as a\n" +
+			"string when it is not valid JSON.",
+		Args:        cobra.ExactArgs(2),
+		Annotations: stateKeyArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			current, _ := m.shell.GetState(args[0])
+			value, err := parseStateValue(current, args[1])
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", args[0], err)
+			}
+			return m.shell.SetState(args[0], value)
+		},
+	})
+	stateCmd.AddCommand(&cobra.Command{
+		Use:         "delete [key]",
+		Short:       "Remove a state key",
+		Args:        cobra.ExactArgs(1),
+		Annotations: stateKeyArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ok, err := m.shell.DeleteState(args[0])
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("state key not found: %s", args[0])
+			}
+			return nil
+		},
+	})
+	stateCmd.AddCommand(&cobra.Command{
+		Use:         "export [file]",
+		Short:       "Save the state as JSON to share with others",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{shellapi.AnnotationFileArgs: "0"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.ExportState(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(m.shell.Stdout(), "State exported to %s\n", args[0])
+			return nil
+		},
+	})
+	stateCmd.AddCommand(&cobra.Command{
+		Use:         "import [file]",
+		Short:       "Load state keys saved with state export",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{shellapi.AnnotationFileArgs: "0"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.shell.ImportState(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(m.shell.Stdout(), "State imported from %s\n", args[0])
+			return nil
+		},
+	})
+	commands = append(commands, stateCmd)
+
+	// Canary command - run a command with a probability or on a sample of targets
+	var canaryPercent float64
+	var canaryTargets string
+	canaryCmd := &cobra.Command{
+		Use:   "canary [command]",
+		Short: "Run a command only for a percentage of invocations or targets",
+		Long: `Run a command with the given probability, or against a random sample of
+targets. With --targets the command runs once per selected target, read
+from a list in shared state; "{}" in the command is replaced by the target,
+otherwise the target is appended as the last argument.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if canaryPercent < 0 || canaryPercent > 100 {
+				return fmt.Errorf("percent must be between 0 and 100")
+			}
+			quoted := make([]string, len(args))
+			for i, arg := range args {
+				quoted[i] = shellapi.QuoteArg(arg)
+			}
+			command := strings.Join(quoted, " ")
+
+			if canaryTargets == "" {
+				roll := rand.Float64() * 100
+				if roll >= canaryPercent {
+					fmt.Fprintf(m.shell.Stdout(), "Canary: skipped (rolled %.1f, threshold %g%%)\n", roll, canaryPercent)
+					return nil
+				}
+				return m.shell.ExecuteCommandContext(cmd.Context(), command)
+			}
+
+			targets, err := m.stateTargets(canaryTargets)
+			if err != nil {
+				return err
+			}
+			count := int(math.Ceil(float64(len(targets)) * canaryPercent / 100))
+			selected := []string{}
+			for _, i := range rand.Perm(len(targets))[:count] {
+				selected = append(selected, targets[i])
+			}
+			fmt.Fprintf(m.shell.Stdout(), "Canary: running on %d of %d targets: %s\n", count, len(targets), strings.Join(selected, ", "))
+
+			for _, target := range selected {
+				targetCommand := command + " " + shellapi.QuoteArg(target)
+				if strings.Contains(command, "{}") {
+					targetCommand = strings.ReplaceAll(command, "{}", shellapi.QuoteArg(target))
+				}
+				if err := m.shell.ExecuteCommandContext(cmd.Context(), targetCommand); err != nil {
+					return fmt.Errorf("%s: %w", target, err)
+				}
+			}
+			return nil
+		},
+	}
+	canaryCmd.Flags().Float64Var(&canaryPercent, "percent", 100, "Percentage of invocations or targets to run on")
+	canaryCmd.Flags().StringVar(&canaryTargets, "targets", "", "State key holding the list of targets")
+	canaryCmd.Flags().SetAnnotation("targets", shellapi.AnnotationStateKeyArgs, []string{"true"})
+	// Flags after the first argument belong to the wrapped command
+	canaryCmd.Flags().SetInterspersed(false)
+	commands = append(commands, canaryCmd)
+
+	// Job commands - list, wait for and stop commands started with a trailing &
+	jobsCmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "List commands running in the background",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			jobs := m.shell.Jobs()
+			if len(jobs) == 0 {
+				fmt.Fprintln(m.shell.Stdout(), "No jobs running")
+				return
+			}
+			rows := make([][]string, 0, len(jobs))
+			for _, job := range jobs {
+				running := time.Since(job.Started).Round(time.Second).String()
+				rows = append(rows, []string{strconv.Itoa(job.ID), running, job.Command})
+			}
+			m.shell.Table([]string{"JOB", "RUNNING", "COMMAND"}, rows)
+		},
+	}
+	commands = append(commands, jobsCmd)
+
+	fgCmd := &cobra.Command{
+		Use:               "fg [job]",
+		Short:             "Wait for a background job, the latest by default",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: m.completeJobs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := m.jobID(args)
+			if err != nil {
+				return err
+			}
+			return m.shell.ForegroundJob(cmd.Context(), id)
+		},
+	}
+	commands = append(commands, fgCmd)
+
+	killCmd := &cobra.Command{
+		Use:               "kill [job]",
+		Short:             "Stop a background job",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: m.completeJobs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := m.jobID(args)
+			if err != nil {
+				return err
+			}
+			return m.shell.KillJob(id)
+		},
+	}
+	commands = append(commands, killCmd)
+
 	return commands
 }
 
+// jobID parses a job number, defaulting to the latest job
+func (m *Module) jobID(args []string) (int, error) {
+	if len(args) == 0 {
+		jobs := m.shell.Jobs()
+		if len(jobs) == 0 {
+			return 0, fmt.Errorf("no jobs running")
+		}
+		return jobs[len(jobs)-1].ID, nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "%"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid job number: %s", args[0])
+	}
+	return id, nil
+}
+
+// completeJobs completes the numbers of running jobs
+func (m *Module) completeJobs(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ids := []string{}
+	for _, job := range m.shell.Jobs() {
+		ids = append(ids, strconv.Itoa(job.ID))
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+// stateTargets reads a list of targets stored in shared state
+func (m *Module) stateTargets(key string) ([]string, error) {
+	value, ok := m.shell.GetState(key)
+	if !ok {
+		return nil, fmt.Errorf("state key not found: %s", key)
+	}
+	switch targets := value.(type) {
+	case []string:
+		return targets, nil
+	case []interface{}:
+		result := make([]string, 0, len(targets))
+		for _, target := range targets {
+			result = append(result, fmt.Sprint(target))
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("state key %s does not hold a list of targets", key)
+}
+
+// formatStateValue shows a state value as JSON, the value's own from
+// MarshalState if it has one, indented if asked. Values that cannot be
+// encoded are shown with %v.
+func formatStateValue(value interface{}, indent bool) string {
+	var data []byte
+	var err error
+	if marshaler, ok := value.(shellapi.StateMarshaler); ok {
+		data, err = marshaler.MarshalState()
+	} else {
+		data, err = json.Marshal(value)
+	}
+	if err != nil || !json.Valid(data) {
+		return fmt.Sprintf("%v", value)
+	}
+	var b bytes.Buffer
+	if indent {
+		json.Indent(&b, data, "", "  ")
+	} else {
+		json.Compact(&b, data)
+	}
+	return b.String()
+}
+
+// formatBytes shows a size in B, KiB or MiB
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// parseStateValue decodes text as JSON into the type of the current value,
+// taking strings as they are. Without a current value the text is decoded
+// as any JSON value, or kept as a string if it is not JSON.
+func parseStateValue(current interface{}, text string) (interface{}, error) {
+	if current == nil {
+		var value interface{}
+		if json.Unmarshal([]byte(text), &value) != nil {
+			return text, nil
+		}
+		return value, nil
+	}
+	if _, ok := current.(string); ok {
+		return text, nil
+	}
+	ptr := reflect.New(reflect.TypeOf(current))
+	if err := json.Unmarshal([]byte(text), ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// completeModules completes the names of modules that are currently enabled
+// or disabled, as asked. The core module cannot be disabled and is left out.
+func (m *Module) completeModules(enabled bool) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		names := []cobra.Completion{}
+		for _, name := range m.shell.GetModules() {
+			if name != "core" && m.shell.IsModuleEnabled(name) == enabled {
+				names = append(names, name)
+			}
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeFeatures completes the names of feature flags that are currently
+// on or off, as asked
+func (m *Module) completeFeatures(enabled bool) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		names := []cobra.Completion{}
+		for _, feature := range m.shell.Features() {
+			if feature.Enabled == enabled {
+				names = append(names, feature.Name)
+			}
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// moduleHeading returns the title of a module in the help output, with its
+// version and description when it has them
+func (m *Module) moduleHeading(name string) string {
+	heading := "[" + name + "]"
+	meta, _ := m.shell.GetModuleMetadata(name)
+	if meta.Version != "" {
+		heading += " " + meta.Version
+	}
+	if meta.Description != "" {
+		heading += " - " + meta.Description
+	}
+	return heading
+}
+
 // InitializeHelp configures the custom help for the shell
 func (m *Module) InitializeHelp() {
 	// Store the default help function so we can call it later
@@ -138,7 +886,7 @@ func (m *Module) InitializeHelp() {
 			}
 
 			// Display commands by module
-			fmt.Println("Available commands:")
+			fmt.Fprintln(m.shell.Stdout(), "Available commands:")
 			enabledModules := m.shell.GetEnabledModules()
 
 			for _, moduleName := range enabledModules {
@@ -146,9 +894,13 @@ func (m *Module) InitializeHelp() {
 				if !ok || len(cmds) == 0 {
 					continue
 				}
-				fmt.Printf("\n[%s]\n", moduleName)
+				fmt.Fprintf(m.shell.Stdout(), "\n%s\n", m.moduleHeading(moduleName))
 				for _, cmd := range cmds {
-					fmt.Printf("  %-15s %s\n", cmd.Name(), cmd.Short)
+					name := cmd.Name()
+					if len(cmd.Aliases) > 0 {
+						name += " (" + strings.Join(cmd.Aliases, ", ") + ")"
+					}
+					fmt.Fprintf(m.shell.Stdout(), "  %-15s %s\n", name, cmd.Short)
 				}
 			}
 
@@ -157,10 +909,10 @@ func (m *Module) InitializeHelp() {
 			for _, moduleName := range m.shell.GetModules() {
 				if !m.shell.IsModuleEnabled(moduleName) {
 					if !hasDisabledModules {
-						fmt.Println("\nDisabled modules:")
+						fmt.Fprintln(m.shell.Stdout(), "\nDisabled modules:")
 						hasDisabledModules = true
 					}
-					fmt.Printf("  %s\n", moduleName)
+					fmt.Fprintf(m.shell.Stdout(), "  %s\n", moduleName)
 				}
 			}
 		} else if len(args) > 0 {
@@ -170,17 +922,17 @@ func (m *Module) InitializeHelp() {
 
 			// Find the command and print its help
 			for _, subCmd := range m.shell.GetRootCmd().Commands() {
-				if subCmd.Name() == cmdName || subCmd.NameAndAliases() == cmdName {
-					fmt.Printf("Command: %s\n", subCmd.Name())
-					fmt.Printf("Usage: %s\n", subCmd.Use)
+				if subCmd.Name() == cmdName || subCmd.HasAlias(cmdName) {
+					fmt.Fprintf(m.shell.Stdout(), "Command: %s\n", subCmd.Name())
+					fmt.Fprintf(m.shell.Stdout(), "Usage: %s\n", subCmd.Use)
 					if subCmd.Short != "" {
-						fmt.Printf("\n%s\n", subCmd.Short)
+						fmt.Fprintf(m.shell.Stdout(), "\n%s\n", subCmd.Short)
 					}
 					if subCmd.Long != "" {
-						fmt.Printf("\n%s\n", subCmd.Long)
+						fmt.Fprintf(m.shell.Stdout(), "\n%s\n", subCmd.Long)
 					}
 					if len(subCmd.Aliases) > 0 {
-						fmt.Printf("\nAliases: %s\n", strings.Join(subCmd.Aliases, ", "))
+						fmt.Fprintf(m.shell.Stdout(), "\nAliases: %s\n", strings.Join(subCmd.Aliases, ", "))
 					}
 					found = true
 					break
@@ -188,13 +940,21 @@ func (m *Module) InitializeHelp() {
 			}
 
 			if !found {
-				fmt.Printf("Unknown command: %s\n", cmdName)
+				fmt.Fprintf(m.shell.Stdout(), "Unknown command: %s\n", cmdName)
 			}
 		} else {
 			// For any other case, use the default help
 			defaultHelpFunc(cmd, args)
 		}
 	})
+
+	// Page long help output; cobra adds the same help command on every run
+	m.shell.GetRootCmd().InitDefaultHelpCmd()
+	for _, cmd := range m.shell.GetRootCmd().Commands() {
+		if cmd.Name() == "help" {
+			cmd.Annotations = map[string]string{shellapi.AnnotationPager: "true"}
+		}
+	}
 }
 
 // Initialize sets up the module
@@ -0,0 +1,288 @@
+// Package grpcreflect provides a module exposing the methods of a gRPC
+// server with reflection enabled, for debugging services interactively.
+//
+// The server's services are discovered when the module is created. The
+// module's command lists and describes them and calls unary methods with
+// JSON request bodies, completing method names:
+//
+//	> orders list
+//	> orders describe shop.Orders/Get
+//	> orders call shop.Orders/Get {"id": "42"}
+package grpcreflect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Option configures the module
+type Option func(*Module)
+
+// WithDialOptions replaces the options used to connect, which default to an
+// insecure connection
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(m *Module) {
+		m.dialOptions = opts
+	}
+}
+
+// WithTimeout sets how long discovery and each call may take
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *Module) {
+		m.timeout = timeout
+	}
+}
+
+// Module exposes the methods of a gRPC server as commands
+type Module struct {
+	shell       shellapi.ShellAPI
+	name        string
+	conn        *grpc.ClientConn
+	dialOptions []grpc.DialOption
+	timeout     time.Duration
+	methods     map[string]protoreflect.MethodDescriptor
+}
+
+// New connects to the server at target and discovers its services through
+// reflection. The module and its command are called name.
+func New(name, target string, opts ...Option) (*Module, error) {
+	m := &Module{
+		name:        name,
+		dialOptions: []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		timeout:     10 * time.Second,
+		methods:     make(map[string]protoreflect.MethodDescriptor),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	conn, err := grpc.NewClient(target, m.dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	services, err := resolveServices(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	m.conn = conn
+
+	for _, service := range services {
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			method := methods.Get(i)
+			m.methods[methodName(method)] = method
+		}
+	}
+	return m, nil
+}
+
+// Close closes the connection to the server
+func (m *Module) Close() error {
+	return m.conn.Close()
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Metadata describes the module
+func (m *Module) Metadata() shellapi.ModuleMetadata {
+	return shellapi.ModuleMetadata{Description: "Methods of the gRPC server at " + m.conn.Target()}
+}
+
+// Health reports whether the connection to the server is failing
+func (m *Module) Health() error {
+	if state := m.conn.GetState(); state == connectivity.TransientFailure || state == connectivity.Shutdown {
+		return fmt.Errorf("connection to %s is %s", m.conn.Target(), strings.ToLower(state.String()))
+	}
+	return nil
+}
+
+// Capabilities declares that the module calls the gRPC server
+func (m *Module) Capabilities() []shellapi.Capability {
+	return []shellapi.Capability{shellapi.CapabilityNetwork}
+}
+
+// Initialize stores the shell reference
+func (m *Module) Initialize(s shellapi.ShellAPI) {
+	m.shell = s
+}
+
+// GetCommands returns the command grouping list, describe and call
+func (m *Module) GetCommands() []*cobra.Command {
+	root := &cobra.Command{
+		Use:   m.name,
+		Short: fmt.Sprintf("Call methods of the %s gRPC server", m.name),
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list [service]",
+		Short: "List services, or the methods of a service",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			service := ""
+			if len(args) == 1 {
+				service = args[0] + "/"
+			}
+			seen := make(map[string]bool)
+			for _, name := range m.methodNames() {
+				switch {
+				case service == "":
+					svc, _, _ := strings.Cut(name, "/")
+					if !seen[svc] {
+						seen[svc] = true
+						fmt.Fprintln(m.shell.Stdout(), svc)
+					}
+				case strings.HasPrefix(name, service):
+					fmt.Fprintln(m.shell.Stdout(), name)
+				}
+			}
+		},
+	}
+
+	describeCmd := &cobra.Command{
+		Use:               "describe [method]",
+		Short:             "Show the request and response types of a method",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: m.completeMethods,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			method, err := m.method(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(m.shell.Stdout(), "rpc %s(%s%s) returns (%s%s)\n", method.Name(),
+				streamPrefix(method.IsStreamingClient()), method.Input().FullName(),
+				streamPrefix(method.IsStreamingServer()), method.Output().FullName())
+			describeMessage(m.shell.Stdout(), "  request", method.Input())
+			describeMessage(m.shell.Stdout(), "  response", method.Output())
+			return nil
+		},
+	}
+
+	callCmd := &cobra.Command{
+		Use:               "call [method] [json]",
+		Short:             "Call a unary method with a JSON request body",
+		Annotations:       map[string]string{shellapi.AnnotationRawArgs: "true"},
+		ValidArgsFunction: m.completeMethods,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || strings.TrimSpace(args[0]) == "" {
+				return fmt.Errorf("usage: %s call <service/method> [json]", m.name)
+			}
+			name, body, _ := strings.Cut(strings.TrimSpace(args[0]), " ")
+			return m.call(cmd.Context(), name, strings.TrimSpace(body))
+		},
+	}
+
+	root.AddCommand(listCmd, describeCmd, callCmd)
+	return []*cobra.Command{root}
+}
+
+// call invokes a unary method, printing the response as JSON. The call
+// is abandoned when ctx is canceled.
+func (m *Module) call(ctx context.Context, name, body string) error {
+	method, err := m.method(name)
+	if err != nil {
+		return err
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("%s is a streaming method, only unary methods can be called", name)
+	}
+
+	req := dynamicpb.NewMessage(method.Input())
+	if body != "" {
+		if err := protojson.Unmarshal([]byte(body), req); err != nil {
+			return fmt.Errorf("invalid request body: %w", err)
+		}
+	}
+	resp := dynamicpb.NewMessage(method.Output())
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	if err := m.conn.Invoke(ctx, "/"+name, req, resp); err != nil {
+		return err
+	}
+
+	out, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(m.shell.Stdout(), string(out))
+	return nil
+}
+
+// method finds a method by its service/method name
+func (m *Module) method(name string) (protoreflect.MethodDescriptor, error) {
+	method, ok := m.methods[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown method: %s", name)
+	}
+	return method, nil
+}
+
+// methodNames returns the service/method names of every method, sorted
+func (m *Module) methodNames() []string {
+	names := make([]string, 0, len(m.methods))
+	for name := range m.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeMethods completes method names for the first argument
+func (m *Module) completeMethods(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return m.methodNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+// methodName returns the service/method name used to invoke a method
+func methodName(method protoreflect.MethodDescriptor) string {
+	return string(method.Parent().FullName()) + "/" + string(method.Name())
+}
+
+// streamPrefix marks streamed messages in a method signature
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+// describeMessage prints the fields of a message type to w
+func describeMessage(w io.Writer, label string, msg protoreflect.MessageDescriptor) {
+	fmt.Fprintf(w, "%s %s {\n", label, msg.FullName())
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		kind := field.Kind().String()
+		if field.Message() != nil {
+			kind = string(field.Message().FullName())
+		} else if field.Enum() != nil {
+			kind = string(field.Enum().FullName())
+		}
+		if field.IsList() {
+			kind = "repeated " + kind
+		}
+		fmt.Fprintf(w, "    %s %s = %d;\n", kind, field.JSONName(), field.Number())
+	}
+	fmt.Fprintln(w, "  }")
+}
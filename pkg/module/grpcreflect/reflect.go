@@ -0,0 +1,150 @@
+package grpcreflect
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// reflectionClient resolves descriptors through the server reflection service
+type reflectionClient struct {
+	stream reflectionpb.ServerReflection_ServerReflectionInfoClient
+	files  map[string]*descriptorpb.FileDescriptorProto
+}
+
+// newReflectionClient opens a reflection stream on conn
+func newReflectionClient(ctx context.Context, conn *grpc.ClientConn) (*reflectionClient, error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection unavailable: %w", err)
+	}
+	return &reflectionClient{
+		stream: stream,
+		files:  make(map[string]*descriptorpb.FileDescriptorProto),
+	}, nil
+}
+
+// request sends one reflection request and waits for its response
+func (c *reflectionClient) request(req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	if err := c.stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("reflection error: %s", e.GetErrorMessage())
+	}
+	return resp, nil
+}
+
+// listServices returns the names of the services the server exposes
+func (c *reflectionClient) listServices() ([]string, error) {
+	resp, err := c.request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		names = append(names, service.GetName())
+	}
+	return names, nil
+}
+
+// addFiles records the file descriptors in a response and fetches any
+// dependency the server did not include
+func (c *reflectionClient) addFiles(resp *reflectionpb.ServerReflectionResponse) error {
+	pending := []*descriptorpb.FileDescriptorProto{}
+	for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		file := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(raw, file); err != nil {
+			return err
+		}
+		if _, ok := c.files[file.GetName()]; !ok {
+			c.files[file.GetName()] = file
+			pending = append(pending, file)
+		}
+	}
+	for _, file := range pending {
+		for _, dep := range file.GetDependency() {
+			if _, ok := c.files[dep]; ok {
+				continue
+			}
+			resp, err := c.request(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			})
+			if err != nil {
+				return err
+			}
+			if err := c.addFiles(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fileContainingSymbol fetches the file defining a fully qualified symbol
+func (c *reflectionClient) fileContainingSymbol(symbol string) error {
+	resp, err := c.request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	})
+	if err != nil {
+		return err
+	}
+	return c.addFiles(resp)
+}
+
+// registry builds a descriptor registry from every file fetched so far
+func (c *reflectionClient) registry() (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range c.files {
+		set.File = append(set.File, file)
+	}
+	return protodesc.NewFiles(set)
+}
+
+// resolveServices fetches the descriptors of every service on the server
+func resolveServices(ctx context.Context, conn *grpc.ClientConn) ([]protoreflect.ServiceDescriptor, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client, err := newReflectionClient(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	names, err := client.listServices()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := client.fileContainingSymbol(name); err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", name, err)
+		}
+	}
+	files, err := client.registry()
+	if err != nil {
+		return nil, err
+	}
+
+	services := []protoreflect.ServiceDescriptor{}
+	for _, name := range names {
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", name, err)
+		}
+		if service, ok := desc.(protoreflect.ServiceDescriptor); ok {
+			services = append(services, service)
+		}
+	}
+	return services, nil
+}
@@ -14,3 +14,62 @@ type CommandModule interface {
 	// Initialize is called when the module is registered
 	Initialize(shell shellapi.ShellAPI)
 }
+
+// Configurable is implemented by modules taking settings from the shell's
+// config file. Configure is called with the module's section, empty if the
+// file has none, before GetCommands and Initialize, so the config can decide
+// which commands and flags the module builds.
+type Configurable interface {
+	Configure(cfg map[string]interface{})
+}
+
+// HealthChecker is implemented by modules that can tell whether their
+// backend is reachable. Health returns nil when the module is healthy; the
+// status command reports the error otherwise.
+type HealthChecker interface {
+	Health() error
+}
+
+// Enabler is implemented by modules with work to start while enabled, such
+// as background workers. OnEnable is called after Initialize and each time
+// the module is enabled again.
+type Enabler interface {
+	OnEnable(shell shellapi.ShellAPI)
+}
+
+// Disabler is implemented by modules with work to stop while disabled.
+// OnDisable is called each time the module is disabled.
+type Disabler interface {
+	OnDisable(shell shellapi.ShellAPI)
+}
+
+// CapabilityUser is implemented by modules declaring the capabilities they
+// need. With capability checks on, a module is only registered if the
+// application granted all of them.
+type CapabilityUser interface {
+	Capabilities() []shellapi.Capability
+}
+
+// Prioritizer is implemented by modules that must come before or after
+// others. Modules are listed in help and initialized by RegisterModules in
+// ascending priority, then registration order; modules without a Priority
+// method have priority 0. Hooks run in the order they were added, whatever
+// the priority of their module.
+type Prioritizer interface {
+	Priority() int
+}
+
+// Describer is implemented by modules documenting themselves. The metadata
+// is shown by the modules command and the module-grouped help.
+type Describer interface {
+	Metadata() shellapi.ModuleMetadata
+}
+
+// Aliaser is implemented by modules declaring short forms of their
+// commands. Aliases maps a command path, relative to the module's commands
+// such as "state list", to its aliases. They are added to the commands'
+// cobra Aliases when the commands are registered, so they run, complete and
+// appear in help like the full names.
+type Aliaser interface {
+	Aliases() map[string][]string
+}
@@ -0,0 +1,349 @@
+// Package openapi generates a command module from an OpenAPI 3 or Swagger 2
+// document, giving API owners an interactive console for their service.
+//
+// Every operation becomes a subcommand of a command named after the module,
+// named from its operationId (listPets becomes list-pets). Path, query,
+// header and cookie parameters become flags, and operations taking a request
+// body get a --body flag accepting JSON or @file. Only JSON documents are
+// supported.
+package openapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// CredentialSource returns the credential for a security scheme declared in
+// the document: a token for bearer, OAuth2 and API key schemes, or
+// "user:password" for basic authentication. It is where a keyring or other
+// credential store plugs in.
+type CredentialSource func(scheme string) (string, error)
+
+// Option configures a generated module
+type Option func(*Module)
+
+// WithBaseURL overrides the server URL taken from the document
+func WithBaseURL(baseURL string) Option {
+	return func(m *Module) {
+		m.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithCredentials supplies credentials for the document's security schemes.
+// Without it requests are sent unauthenticated.
+func WithCredentials(fn CredentialSource) Option {
+	return func(m *Module) {
+		m.credentials = fn
+	}
+}
+
+// WithHTTPClient sets the client used to send requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(m *Module) {
+		m.client = client
+	}
+}
+
+// Module is a command module generated from an OpenAPI document
+type Module struct {
+	shell       shellapi.ShellAPI
+	name        string
+	doc         *document
+	baseURL     string
+	credentials CredentialSource
+	client      *http.Client
+	command     *cobra.Command
+}
+
+// New generates a module named name from a JSON OpenAPI document
+func New(name string, spec []byte, opts ...Option) (*Module, error) {
+	doc, err := parseDocument(spec)
+	if err != nil {
+		return nil, err
+	}
+	m := &Module{
+		name:    name,
+		doc:     doc,
+		baseURL: doc.baseURL(),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.command, err = m.buildCommand()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load generates a module from an OpenAPI document on disk
+func Load(name, path string, opts ...Option) (*Module, error) {
+	spec, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(name, spec, opts...)
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Metadata describes the module with the document's title and version
+func (m *Module) Metadata() shellapi.ModuleMetadata {
+	description := m.doc.Info.Title
+	if description == "" {
+		description = fmt.Sprintf("The %s API", m.name)
+	}
+	return shellapi.ModuleMetadata{Version: m.doc.Info.Version, Description: description}
+}
+
+// Capabilities declares that the module calls the API's servers
+func (m *Module) Capabilities() []shellapi.Capability {
+	return []shellapi.Capability{shellapi.CapabilityNetwork}
+}
+
+// Initialize stores the shell reference
+func (m *Module) Initialize(s shellapi.ShellAPI) {
+	m.shell = s
+}
+
+// GetCommands returns the command grouping the generated operations
+func (m *Module) GetCommands() []*cobra.Command {
+	return []*cobra.Command{m.command}
+}
+
+// buildCommand creates the module command with a subcommand per operation
+func (m *Module) buildCommand() (*cobra.Command, error) {
+	root := &cobra.Command{
+		Use:   m.name,
+		Short: fmt.Sprintf("Call the %s API", m.name),
+	}
+
+	paths := make([]string, 0, len(m.doc.Paths))
+	for path := range m.doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	seen := make(map[string]string)
+	for _, path := range paths {
+		item := m.doc.Paths[path]
+		for _, mo := range item.operations() {
+			name := commandName(mo.method, path, mo.op)
+			if previous, ok := seen[name]; ok {
+				return nil, fmt.Errorf("operations %s and %s %s both map to command %q", previous, mo.method, path, name)
+			}
+			seen[name] = mo.method + " " + path
+			root.AddCommand(m.operationCommand(name, mo.method, path, item, mo.op))
+		}
+	}
+	return root, nil
+}
+
+// operationCommand creates the command calling one operation
+func (m *Module) operationCommand(name, method, path string, item pathItem, op *operation) *cobra.Command {
+	params := []parameter{}
+	for _, p := range append(append([]parameter{}, item.Parameters...), op.Parameters...) {
+		if p, ok := m.doc.resolve(p); ok {
+			params = append(params, p)
+		}
+	}
+
+	short := op.Summary
+	if short == "" {
+		short = method + " " + path
+	}
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: short,
+		Long:  strings.TrimSpace(op.Description + "\n\n" + method + " " + path),
+		Args:  cobra.NoArgs,
+	}
+
+	values := make(map[string]*string)
+	hasBody := op.RequestBody != nil
+	for _, p := range params {
+		if p.In == "body" {
+			hasBody = true
+			continue
+		}
+		flag := kebab(p.Name)
+		if _, ok := values[flag]; ok {
+			continue
+		}
+		values[flag] = cmd.Flags().String(flag, "", strings.TrimSpace(fmt.Sprintf("%s (%s parameter)", p.Description, p.In)))
+		if p.Required || p.In == "path" {
+			cmd.MarkFlagRequired(flag)
+		}
+	}
+	var body string
+	if hasBody {
+		cmd.Flags().StringVar(&body, "body", "", "Request body as JSON, or @file to read it from a file")
+	}
+
+	cmd.RunE = func(c *cobra.Command, args []string) error {
+		req, err := m.newRequest(method, path, params, values, body, op)
+		if err != nil {
+			return err
+		}
+		return m.send(req.WithContext(c.Context()))
+	}
+	return cmd
+}
+
+// newRequest builds the HTTP request for an operation from the flag values
+func (m *Module) newRequest(method, path string, params []parameter, values map[string]*string, body string, op *operation) (*http.Request, error) {
+	if m.baseURL == "" || strings.HasPrefix(m.baseURL, "/") {
+		return nil, fmt.Errorf("no server URL for %s, use openapi.WithBaseURL", m.name)
+	}
+
+	query := url.Values{}
+	headers := http.Header{}
+	cookies := []*http.Cookie{}
+	for _, p := range params {
+		value, ok := values[kebab(p.Name)]
+		if !ok || *value == "" {
+			continue
+		}
+		switch p.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", url.PathEscape(*value))
+		case "query":
+			query.Set(p.Name, *value)
+		case "header":
+			headers.Set(p.Name, *value)
+		case "cookie":
+			cookies = append(cookies, &http.Cookie{Name: p.Name, Value: *value})
+		}
+	}
+
+	var reader io.Reader
+	if body != "" {
+		if file, ok := strings.CutPrefix(body, "@"); ok {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			body = string(data)
+		}
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, m.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = query.Encode()
+	for key, vals := range headers {
+		req.Header[key] = vals
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if err := m.authorize(req, op); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// authorize applies the credentials for the first security requirement of
+// the operation, or of the document when the operation declares none
+func (m *Module) authorize(req *http.Request, op *operation) error {
+	if m.credentials == nil {
+		return nil
+	}
+	requirements := m.doc.Security
+	if op.Security != nil {
+		requirements = *op.Security
+	}
+	if len(requirements) == 0 {
+		return nil
+	}
+	schemes := m.doc.securitySchemes()
+
+	names := make([]string, 0, len(requirements[0]))
+	for name := range requirements[0] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		scheme, ok := schemes[name]
+		if !ok {
+			continue
+		}
+		credential, err := m.credentials(name)
+		if err != nil {
+			return fmt.Errorf("credentials for %s: %w", name, err)
+		}
+		if credential != "" {
+			applyCredential(req, scheme, credential)
+		}
+	}
+	return nil
+}
+
+// applyCredential sends a credential the way its scheme describes
+func applyCredential(req *http.Request, scheme securityScheme, credential string) {
+	switch {
+	case scheme.Type == "apiKey" && scheme.In == "query":
+		q := req.URL.Query()
+		q.Set(scheme.Name, credential)
+		req.URL.RawQuery = q.Encode()
+	case scheme.Type == "apiKey" && scheme.In == "cookie":
+		req.AddCookie(&http.Cookie{Name: scheme.Name, Value: credential})
+	case scheme.Type == "apiKey":
+		req.Header.Set(scheme.Name, credential)
+	case scheme.Type == "basic" || strings.EqualFold(scheme.Scheme, "basic"):
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credential)))
+	default:
+		// bearer, oauth2 and openIdConnect all send a bearer token
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+}
+
+// send performs the request and prints the response, indenting JSON bodies
+func (m *Module) send(req *http.Request) error {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, data, "", "  ") == nil {
+		data = pretty.Bytes()
+	}
+
+	fmt.Fprintf(m.shell.Stdout(), "%s %s\n", resp.Proto, resp.Status)
+	if len(data) > 0 {
+		fmt.Fprintln(m.shell.Stdout(), strings.TrimRight(string(data), "\n"))
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+	return nil
+}
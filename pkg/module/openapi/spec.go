@@ -0,0 +1,193 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// document is the subset of an OpenAPI 3 or Swagger 2 document used to
+// generate commands
+type document struct {
+	Swagger string `json:"swagger"`
+	OpenAPI string `json:"openapi"`
+
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+
+	// OpenAPI 3 servers, Swagger 2 host and base path
+	Servers  []struct{ URL string } `json:"servers"`
+	Host     string                 `json:"host"`
+	BasePath string                 `json:"basePath"`
+	Schemes  []string               `json:"schemes"`
+
+	Paths    map[string]pathItem   `json:"paths"`
+	Security []map[string][]string `json:"security"`
+
+	Components struct {
+		Parameters      map[string]parameter      `json:"parameters"`
+		SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+	} `json:"components"`
+	Parameters          map[string]parameter      `json:"parameters"`
+	SecurityDefinitions map[string]securityScheme `json:"securityDefinitions"`
+}
+
+// pathItem holds the operations available on a path
+type pathItem struct {
+	Parameters []parameter `json:"parameters"`
+	Get        *operation  `json:"get"`
+	Put        *operation  `json:"put"`
+	Post       *operation  `json:"post"`
+	Delete     *operation  `json:"delete"`
+	Patch      *operation  `json:"patch"`
+	Head       *operation  `json:"head"`
+	Options    *operation  `json:"options"`
+}
+
+// operations returns the operations of the path keyed by HTTP method, in a
+// stable order
+func (p pathItem) operations() []methodOperation {
+	all := []methodOperation{
+		{"GET", p.Get}, {"POST", p.Post}, {"PUT", p.Put}, {"PATCH", p.Patch},
+		{"DELETE", p.Delete}, {"HEAD", p.Head}, {"OPTIONS", p.Options},
+	}
+	ops := []methodOperation{}
+	for _, op := range all {
+		if op.op != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// methodOperation pairs an operation with its HTTP method
+type methodOperation struct {
+	method string
+	op     *operation
+}
+
+// operation is a single API call
+type operation struct {
+	OperationID string                 `json:"operationId"`
+	Summary     string                 `json:"summary"`
+	Description string                 `json:"description"`
+	Parameters  []parameter            `json:"parameters"`
+	RequestBody *struct{}              `json:"requestBody"`
+	Security    *[]map[string][]string `json:"security"`
+}
+
+// parameter is an input to an operation, sent in the path, query, headers
+// or cookies. Swagger 2 body parameters are sent as the request body.
+type parameter struct {
+	Ref         string `json:"$ref"`
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+// securityScheme describes how credentials are sent
+type securityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+	In     string `json:"in"`
+	Name   string `json:"name"`
+}
+
+// parseDocument decodes a JSON OpenAPI 3 or Swagger 2 document
+func parseDocument(spec []byte) (*document, error) {
+	doc := &document{}
+	if err := json.Unmarshal(spec, doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	if doc.OpenAPI == "" && doc.Swagger == "" {
+		return nil, fmt.Errorf("invalid OpenAPI document: missing openapi or swagger version")
+	}
+	return doc, nil
+}
+
+// baseURL returns the URL requests are sent to, from the first server or
+// the Swagger 2 host
+func (d *document) baseURL() string {
+	if len(d.Servers) > 0 {
+		return strings.TrimRight(d.Servers[0].URL, "/")
+	}
+	if d.Host == "" {
+		return ""
+	}
+	scheme := "https"
+	if len(d.Schemes) > 0 {
+		scheme = d.Schemes[0]
+	}
+	return strings.TrimRight(scheme+"://"+d.Host+d.BasePath, "/")
+}
+
+// resolve follows a local parameter reference
+func (d *document) resolve(p parameter) (parameter, bool) {
+	if p.Ref == "" {
+		return p, true
+	}
+	name := p.Ref[strings.LastIndex(p.Ref, "/")+1:]
+	switch {
+	case strings.HasPrefix(p.Ref, "#/components/parameters/"):
+		p, ok := d.Components.Parameters[name]
+		return p, ok
+	case strings.HasPrefix(p.Ref, "#/parameters/"):
+		p, ok := d.Parameters[name]
+		return p, ok
+	}
+	return parameter{}, false
+}
+
+// securitySchemes returns the schemes declared by either spec version
+func (d *document) securitySchemes() map[string]securityScheme {
+	if len(d.Components.SecuritySchemes) > 0 {
+		return d.Components.SecuritySchemes
+	}
+	return d.SecurityDefinitions
+}
+
+// kebab converts an identifier such as listPets or pet_id to list-pets or
+// pet-id
+func kebab(name string) string {
+	var b strings.Builder
+	prevLower := false
+	for _, r := range name {
+		switch {
+		case unicode.IsUpper(r):
+			if prevLower {
+				b.WriteRune('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			prevLower = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			prevLower = true
+		default:
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "-") {
+				b.WriteRune('-')
+			}
+			prevLower = false
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// commandName names the command for an operation, from its operationId or
+// else from the method and the literal path segments
+func commandName(method, path string, op *operation) string {
+	if op.OperationID != "" {
+		return kebab(op.OperationID)
+	}
+	words := []string{strings.ToLower(method)}
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" && !strings.HasPrefix(segment, "{") {
+			words = append(words, segment)
+		}
+	}
+	return kebab(strings.Join(words, "-"))
+}
@@ -0,0 +1,163 @@
+// Package registry maps names to constructors of the bundled modules, so
+// applications can register stock functionality declaratively with
+// RegisterByName instead of importing each package:
+//
+//	registry.RegisterByName(sh, "openapi", "grpc")
+//
+// Constructors receive the module's section of the shell's config file.
+// The bundled modules take:
+//
+//	openapi: spec (path of the document, required), name, base_url
+//	grpc:    target (address of the server, required), name, timeout
+//
+// Applications and third-party packages can add their own with Register.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/module"
+	"github.com/Necromancerlabs/gocmd2/pkg/module/grpcreflect"
+	"github.com/Necromancerlabs/gocmd2/pkg/module/openapi"
+)
+
+// Constructor creates a module from its config section, empty if the
+// config file has none
+type Constructor func(cfg map[string]interface{}) (module.CommandModule, error)
+
+var (
+	constructors = make(map[string]Constructor)
+	mutex        sync.RWMutex
+)
+
+func init() {
+	Register("openapi", newOpenAPI)
+	Register("grpc", newGRPC)
+}
+
+// Register adds a constructor under name, replacing any registered before
+func Register(name string, ctor Constructor) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	constructors[name] = ctor
+}
+
+// Lookup returns the constructor registered under name
+func Lookup(name string) (Constructor, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	ctor, ok := constructors[name]
+	return ctor, ok
+}
+
+// Registrar is the shell modules are registered with, such as a
+// *shell.Shell
+type Registrar interface {
+	ModuleConfig(name string) map[string]interface{}
+	RegisterModule(module module.CommandModule) error
+}
+
+// RegisterByName creates and registers modules from the registry with sh.
+// Each constructor gets the config file section named after it. Every name
+// is tried; the failures are returned together.
+func RegisterByName(sh Registrar, names ...string) error {
+	var errs []error
+	for _, name := range names {
+		ctor, ok := Lookup(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown module: %s", name))
+			continue
+		}
+		mod, err := ctor(sh.ModuleConfig(name))
+		if err == nil {
+			err = sh.RegisterModule(mod)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Names returns the registered names, sorted
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(constructors))
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newOpenAPI creates an openapi module from its config
+func newOpenAPI(cfg map[string]interface{}) (module.CommandModule, error) {
+	spec, err := stringValue(cfg, "spec", "")
+	if err != nil {
+		return nil, err
+	}
+	if spec == "" {
+		return nil, fmt.Errorf("spec is required")
+	}
+	name, err := stringValue(cfg, "name", "api")
+	if err != nil {
+		return nil, err
+	}
+	baseURL, err := stringValue(cfg, "base_url", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []openapi.Option
+	if baseURL != "" {
+		opts = append(opts, openapi.WithBaseURL(baseURL))
+	}
+	return openapi.Load(name, spec, opts...)
+}
+
+// newGRPC creates a grpcreflect module from its config
+func newGRPC(cfg map[string]interface{}) (module.CommandModule, error) {
+	target, err := stringValue(cfg, "target", "")
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		return nil, fmt.Errorf("target is required")
+	}
+	name, err := stringValue(cfg, "name", "grpc")
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := stringValue(cfg, "timeout", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []grpcreflect.Option
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		opts = append(opts, grpcreflect.WithTimeout(d))
+	}
+	return grpcreflect.New(name, target, opts...)
+}
+
+// stringValue returns the string under key, or def if it is missing
+func stringValue(cfg map[string]interface{}, key, def string) (string, error) {
+	value, ok := cfg[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, not %T", key, value)
+	}
+	return s, nil
+}
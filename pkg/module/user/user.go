@@ -0,0 +1,345 @@
+// Package user provides the module holding user-defined functions.
+//
+// A function is a named list of shell commands separated by semicolons,
+// with optional parameters. Bodies can declare local variables, and both
+// parameters and locals are expanded with $name or ${name}:
+//
+//	> function deploy env { local image=app:$env; build $image; push $image }
+//	> deploy staging
+//
+// $? expands to the exit status of the previous command.
+//
+// Definitions are saved to a file and become commands of the user module,
+// so they are listed by help and complete like any other command.
+package user
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// maxDepth bounds nested function calls, stopping runaway recursion
+const maxDepth = 32
+
+// depthKey is the context key holding how deeply function calls are nested
+type depthKey struct{}
+
+// namePattern matches valid function names
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// identPattern matches valid parameter and variable names
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// variablePattern matches $name and ${name} references, and $? for the
+// status of the previous command
+var variablePattern = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*|\?))`)
+
+// function is a user-defined command
+type function struct {
+	name       string
+	params     []string
+	statements []string
+	command    *cobra.Command
+}
+
+// definition returns the source text the function was defined with
+func (f *function) definition() string {
+	head := strings.Join(append([]string{f.name}, f.params...), " ")
+	return fmt.Sprintf("%s { %s }", head, strings.Join(f.statements, "; "))
+}
+
+// Module holds user-defined functions
+type Module struct {
+	shell     shellapi.ShellAPI
+	path      string
+	functions map[string]*function
+}
+
+// New creates the user module, saving definitions to path. An empty path
+// keeps them for the current session only.
+func New(path string) *Module {
+	return &Module{
+		path:      path,
+		functions: make(map[string]*function),
+	}
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "user"
+}
+
+// Metadata describes the user module
+func (m *Module) Metadata() shellapi.ModuleMetadata {
+	return shellapi.ModuleMetadata{Description: "User-defined functions"}
+}
+
+// Initialize stores the shell reference and loads saved definitions
+func (m *Module) Initialize(s shellapi.ShellAPI) {
+	m.shell = s
+	if err := m.load(); err != nil {
+		m.shell.Warn("Could not load functions: %v", err)
+	}
+}
+
+// GetCommands returns the commands defining and listing functions
+func (m *Module) GetCommands() []*cobra.Command {
+	functionCmd := &cobra.Command{
+		Use:   "function [name] [params...] { [commands; ...] }",
+		Short: "Define a function made of shell commands",
+		Long: "Define a function made of shell commands separated by semicolons.\n" +
+			"Parameters and variables declared with 'local name=value' are expanded\n" +
+			"with $name or ${name}.",
+		Annotations: map[string]string{shellapi.AnnotationRawArgs: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("usage: function <name> [params...] { <commands> }")
+			}
+			if err := m.define(args[0]); err != nil {
+				return err
+			}
+			return m.save()
+		},
+	}
+
+	functionsCmd := &cobra.Command{
+		Use:   "functions",
+		Short: "List user-defined functions",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(m.functions) == 0 {
+				fmt.Fprintln(m.shell.Stdout(), "No functions defined")
+				return
+			}
+			for _, name := range m.names() {
+				fmt.Fprintf(m.shell.Stdout(), "function %s\n", m.functions[name].definition())
+			}
+		},
+	}
+	functionsCmd.AddCommand(&cobra.Command{
+		Use:   "remove [name]",
+		Short: "Remove a user-defined function",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+			return m.names(), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.remove(args[0]); err != nil {
+				return err
+			}
+			return m.save()
+		},
+	})
+
+	return []*cobra.Command{functionCmd, functionsCmd}
+}
+
+// define parses a definition and registers the function, replacing any
+// earlier definition with the same name
+func (m *Module) define(source string) error {
+	fn, err := parse(source)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.functions[fn.name]; !ok {
+		for _, cmd := range m.shell.GetRootCmd().Commands() {
+			if cmd.Name() == fn.name || cmd.HasAlias(fn.name) {
+				return fmt.Errorf("command already exists: %s", fn.name)
+			}
+		}
+	}
+	if _, ok := m.functions[fn.name]; ok {
+		if err := m.remove(fn.name); err != nil {
+			return err
+		}
+	}
+
+	fn.command = m.command(fn)
+	if err := m.shell.RegisterCommands(m.Name(), []*cobra.Command{fn.command}); err != nil {
+		return err
+	}
+	m.functions[fn.name] = fn
+	return nil
+}
+
+// remove unregisters a function
+func (m *Module) remove(name string) error {
+	fn, ok := m.functions[name]
+	if !ok {
+		return fmt.Errorf("function not found: %s", name)
+	}
+	if err := m.shell.UnregisterCommands(m.Name(), []*cobra.Command{fn.command}); err != nil {
+		return err
+	}
+	delete(m.functions, name)
+	return nil
+}
+
+// command creates the command running a function
+func (m *Module) command(fn *function) *cobra.Command {
+	use := fn.name
+	for _, param := range fn.params {
+		use += " [" + param + "]"
+	}
+	return &cobra.Command{
+		Use:                use,
+		Short:              "function: " + strings.Join(fn.statements, "; "),
+		Args:               cobra.ExactArgs(len(fn.params)),
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return m.call(cmd.Context(), fn, args)
+		},
+	}
+}
+
+// call runs the statements of a function in order under ctx, stopping at
+// the first failing command. The depth of nested calls travels in ctx, so
+// functions running as background jobs count their own.
+func (m *Module) call(ctx context.Context, fn *function, args []string) error {
+	depth, _ := ctx.Value(depthKey{}).(int)
+	if depth >= maxDepth {
+		return fmt.Errorf("function %s: call depth exceeded", fn.name)
+	}
+	ctx = context.WithValue(ctx, depthKey{}, depth+1)
+
+	vars := make(map[string]string)
+	for i, param := range fn.params {
+		vars[param] = args[i]
+	}
+	for _, statement := range fn.statements {
+		vars["?"] = strconv.Itoa(m.shell.LastStatus())
+		if decl, ok := strings.CutPrefix(statement, "local "); ok {
+			decl, err := expand(decl, vars, false)
+			if err != nil {
+				return fmt.Errorf("function %s: %w", fn.name, err)
+			}
+			name, value, _ := strings.Cut(strings.TrimSpace(decl), "=")
+			if !identPattern.MatchString(name) {
+				return fmt.Errorf("function %s: invalid variable name: %s", fn.name, name)
+			}
+			vars[name] = value
+			continue
+		}
+		line, err := expand(statement, vars, true)
+		if err != nil {
+			return fmt.Errorf("function %s: %w", fn.name, err)
+		}
+		if err := m.shell.ExecuteCommandContext(ctx, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// names returns the names of the defined functions, sorted
+func (m *Module) names() []string {
+	names := make([]string, 0, len(m.functions))
+	for name := range m.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// load defines the functions saved in the definitions file
+func (m *Module) load() error {
+	if m.path == "" {
+		return nil
+	}
+	f, err := os.Open(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := m.define(strings.TrimPrefix(line, "function ")); err != nil {
+			m.shell.Warn("Skipping function %q: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// save writes every definition to the definitions file
+func (m *Module) save() error {
+	if m.path == "" {
+		return nil
+	}
+	var b strings.Builder
+	for _, name := range m.names() {
+		b.WriteString("function " + m.functions[name].definition() + "\n")
+	}
+	return os.WriteFile(m.path, []byte(b.String()), 0600)
+}
+
+// parse reads a definition of the form: name [params...] { statement; ... }
+func parse(source string) (*function, error) {
+	head, body, ok := strings.Cut(source, "{")
+	body, found := strings.CutSuffix(strings.TrimSpace(body), "}")
+	if !ok || !found {
+		return nil, fmt.Errorf("function body must be enclosed in { }")
+	}
+
+	words := strings.Fields(head)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("function name required")
+	}
+	if !namePattern.MatchString(words[0]) {
+		return nil, fmt.Errorf("invalid function name: %s", words[0])
+	}
+	for _, param := range words[1:] {
+		if !identPattern.MatchString(param) {
+			return nil, fmt.Errorf("invalid parameter name: %s", param)
+		}
+	}
+
+	fn := &function{name: words[0], params: words[1:]}
+	for _, statement := range strings.Split(body, ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			fn.statements = append(fn.statements, statement)
+		}
+	}
+	if len(fn.statements) == 0 {
+		return nil, fmt.Errorf("function %s has no commands", fn.name)
+	}
+	return fn, nil
+}
+
+// expand replaces variable references in a statement, quoting each value
+// if asked so it stays a single argument of the command line
+func expand(statement string, vars map[string]string, quote bool) (string, error) {
+	var missing string
+	expanded := variablePattern.ReplaceAllStringFunc(statement, func(ref string) string {
+		match := variablePattern.FindStringSubmatch(ref)
+		name := match[1] + match[2]
+		value, ok := vars[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		if quote {
+			return shellapi.QuoteArg(value)
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("undefined variable: %s", missing)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,89 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/module"
+)
+
+// applyAliases adds the aliases a module declares to the commands among
+// cmds they name. Paths naming none of them are left for commands the
+// module registers later. An alias clashing with a sibling command is an
+// error; clashes with other modules are left to the conflict policy.
+func applyAliases(mod module.CommandModule, cmds []*cobra.Command) error {
+	aliaser, ok := mod.(module.Aliaser)
+	if !ok {
+		return nil
+	}
+	var declared map[string][]string
+	if err := callModule(mod.Name(), "Aliases", func() { declared = aliaser.Aliases() }); err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(declared))
+	for path := range declared {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var errs []error
+	for _, path := range paths {
+		cmd, siblings := findCommand(cmds, strings.Fields(path))
+		if cmd == nil {
+			continue
+		}
+		for _, alias := range declared[path] {
+			if alias == cmd.Name() || cmd.HasAlias(alias) {
+				continue
+			}
+			if other := siblingNamed(siblings, cmd, alias); other != nil {
+				errs = append(errs, fmt.Errorf("alias %s of %s in module %s clashes with %s", alias, path, mod.Name(), other.Name()))
+				continue
+			}
+			cmd.Aliases = append(cmd.Aliases, alias)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// findCommand follows a path of command names down from cmds, returning the
+// command found and its siblings
+func findCommand(cmds []*cobra.Command, path []string) (*cobra.Command, []*cobra.Command) {
+	for i, name := range path {
+		idx := slices.IndexFunc(cmds, func(cmd *cobra.Command) bool { return cmd.Name() == name })
+		if idx < 0 {
+			return nil, nil
+		}
+		if i == len(path)-1 {
+			return cmds[idx], cmds
+		}
+		cmds = cmds[idx].Commands()
+	}
+	return nil, nil
+}
+
+// siblingNamed returns the command other than cmd among siblings named or
+// aliased name
+func siblingNamed(siblings []*cobra.Command, cmd *cobra.Command, name string) *cobra.Command {
+	for _, sibling := range siblings {
+		if sibling != cmd && (sibling.Name() == name || sibling.HasAlias(name)) {
+			return sibling
+		}
+	}
+	return nil
+}
+
+// moduleNamed returns the registered module called name
+func (s *Shell) moduleNamed(name string) module.CommandModule {
+	for _, mod := range s.commandModules {
+		if mod.Name() == name {
+			return mod
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// Confirm asks a yes or no question, returning defaultYes when the user
+// just presses Enter. It keeps asking until it gets an answer; Ctrl+C or
+// Ctrl+D answer no.
+func (s *Shell) Confirm(question string, defaultYes bool) bool {
+	choices := "[y/N]"
+	if defaultYes {
+		choices = "[Y/n]"
+	}
+	for {
+		answer, err := s.readAnswer(fmt.Sprintf("%s %s ", question, choices))
+		if err != nil {
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "":
+			return defaultYes
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintln(s.Stdout(), "Please answer y or n")
+	}
+}
+
+// ReadSecret reads a line without echoing it, for passwords and tokens.
+// Nothing typed is shown and the answer is never added to the history.
+// Ctrl+C returns readline.ErrInterrupt.
+func (s *Shell) ReadSecret(prompt string) (string, error) {
+	s.secret = true
+	defer func() { s.secret = false }()
+	return s.readAnswer(prompt)
+}
+
+// secretKey handles a key while ReadSecret runs. Keys that move the cursor
+// or recall history are swallowed, since the hidden line gives no hint of
+// where the cursor is.
+func secretKey(r rune) (rune, bool) {
+	switch r {
+	case readline.CharEnter, readline.CharCtrlJ, readline.CharInterrupt, readline.CharDelete,
+		readline.CharBackspace, readline.CharCtrlH, readline.CharCtrlU, readline.CharCtrlW,
+		readline.MetaBackspace:
+		return r, true
+	}
+	return r, r >= ' '
+}
+
+// readAnswer reads one line through readline with prompt in place of the
+// shell prompt. Completion, suggestions and the palette are off, and the
+// line is kept out of the history. Without a terminal the answer is the
+// next line of input.
+func (s *Shell) readAnswer(prompt string) (string, error) {
+	if !s.Interactive() {
+		return s.readInputLine()
+	}
+	cfg := s.rl.Config
+	saved := s.inputPrompt()
+	autoComplete, painter := cfg.AutoComplete, cfg.Painter
+	cfg.AutoComplete = nil
+	cfg.Painter = painterFunc(s.paintAnswer)
+	s.asking = true
+	s.rl.SetPrompt(prompt)
+	defer func() {
+		cfg.AutoComplete, cfg.Painter = autoComplete, painter
+		s.asking = false
+		s.rl.SetPrompt(saved)
+	}()
+	return s.rl.Readline()
+}
+
+// paintAnswer draws the answer being typed, or nothing but the final
+// newline while ReadSecret runs
+func (s *Shell) paintAnswer(line []rune, pos int) []rune {
+	if s.secret {
+		hidden := []rune{}
+		if strings.HasSuffix(string(line), "\n") {
+			hidden = append(hidden, '\n')
+		}
+		return s.withStatus(hidden)
+	}
+	return s.withStatus(line)
+}
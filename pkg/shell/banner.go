@@ -0,0 +1,64 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"text/template"
+)
+
+// defaultBanner is shown when the shell is created without a banner
+const defaultBanner = "Interactive shell started. Type 'help' for available commands."
+
+// BannerData is available to banner templates, e.g.
+// "{{.Name}} {{.Version}} on {{.Hostname}}"
+type BannerData struct {
+	Name     string
+	Version  string
+	Hostname string
+	Modules  []string
+}
+
+// parseBanner compiles the banner as a template when templating is on
+func (s *Shell) parseBanner() error {
+	if !s.templateBanner {
+		return nil
+	}
+	tmpl, err := template.New("banner").Parse(s.banner)
+	if err != nil {
+		return fmt.Errorf("invalid banner template: %w", err)
+	}
+	s.bannerTemplate = tmpl
+	return nil
+}
+
+// renderBanner returns the banner as it should look now
+func (s *Shell) renderBanner() string {
+	if s.bannerFunc != nil {
+		return s.bannerFunc()
+	}
+	if s.bannerTemplate == nil {
+		if s.banner == "" {
+			return defaultBanner
+		}
+		return s.banner
+	}
+
+	data := BannerData{Name: s.rootCmd.Name(), Modules: s.GetEnabledModules()}
+	data.Hostname, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		data.Version = info.Main.Version
+	}
+	var b strings.Builder
+	if err := s.bannerTemplate.Execute(&b, data); err != nil {
+		s.Warn("Could not render banner: %v", err)
+		return s.banner
+	}
+	return b.String()
+}
+
+// ReprintBanner prints the banner again, rendered with current values
+func (s *Shell) ReprintBanner() {
+	s.rl.Write([]byte(s.aboveInput(strings.TrimSuffix(s.renderBanner(), "\n") + "\n")))
+}
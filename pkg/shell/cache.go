@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// cacheEntry is the output of a cached command invocation
+type cacheEntry struct {
+	output  string
+	expires time.Time
+}
+
+// cacheTTL returns how long results of cmd may be reused, from its
+// shellapi.AnnotationCacheTTL annotation
+func cacheTTL(cmd *cobra.Command) (time.Duration, bool) {
+	value, ok := cmd.Annotations[shellapi.AnnotationCacheTTL]
+	if !ok {
+		return 0, false
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// withCache wraps the run function execute hands the middleware, so a
+// cached command replays the output of an earlier identical invocation
+// when it is still fresh, and otherwise runs and has its output remembered.
+// The middleware runs either way, and the arguments it settled on are the
+// ones looked up.
+func (s *Shell) withCache(args []string, run func(rebuilt []string) error) func(rebuilt []string) error {
+	return func(rebuilt []string) error {
+		line := args
+		if rebuilt != nil {
+			line = rebuilt
+		}
+		cmd, _, err := s.rootCmd.Find(line)
+		if err != nil {
+			return run(rebuilt)
+		}
+		ttl, ok := cacheTTL(cmd)
+		if !ok {
+			return run(rebuilt)
+		}
+
+		key := strings.Join(line, "\x00")
+		s.cacheMutex.Lock()
+		entry, ok := s.cache[key]
+		s.cacheMutex.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			fmt.Fprint(s.Stdout(), entry.output)
+			fmt.Fprintln(s.Stdout(), "(cached)")
+			return nil
+		}
+
+		output, err := s.captureOutput(func() error { return run(rebuilt) }, true)
+		if err != nil {
+			return err
+		}
+		s.cacheMutex.Lock()
+		s.cache[key] = cacheEntry{output: output, expires: time.Now().Add(ttl)}
+		s.cacheMutex.Unlock()
+		return nil
+	}
+}
+
+// ClearCache drops every cached command result
+func (s *Shell) ClearCache() {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	s.cache = make(map[string]cacheEntry)
+}
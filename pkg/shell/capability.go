@@ -0,0 +1,713 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/module"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// GrantCapabilities allows a module the given capabilities when capability
+// checks are on. Grant them before registering the module.
+func (s *Shell) GrantCapabilities(moduleName string, caps ...shellapi.Capability) {
+	if s.grants[moduleName] == nil {
+		s.grants[moduleName] = make(map[shellapi.Capability]bool)
+	}
+	for _, c := range caps {
+		s.grants[moduleName][c] = true
+	}
+}
+
+// moduleAPI returns the ShellAPI handed to a module: the shell itself, or
+// with capability checks on, a view failing calls the module was not
+// granted. Core and user are trusted.
+func (s *Shell) moduleAPI(mod module.CommandModule) (shellapi.ShellAPI, error) {
+	name := mod.Name()
+	if !s.capabilityChecks || name == "core" || name == "user" {
+		return s, nil
+	}
+	granted := s.grants[name]
+	if user, ok := mod.(module.CapabilityUser); ok {
+		for _, c := range user.Capabilities() {
+			if !granted[c] {
+				return nil, fmt.Errorf("module %s needs the %s capability, which was not granted", name, c)
+			}
+		}
+	}
+	return &restrictedShell{shell: s, module: name, granted: granted}, nil
+}
+
+// restrictedShell is the ShellAPI of a module under capability checks.
+// It guards the shell against modules overstepping by mistake; it is not a
+// sandbox, since a module's own code runs in the shell's process. Each
+// method decides what it needs before passing the call on to the shell;
+// those returning no error warn and do nothing when denied.
+type restrictedShell struct {
+	shell   *Shell
+	module  string
+	granted map[shellapi.Capability]bool
+}
+
+// check fails unless the module was granted c
+func (r *restrictedShell) check(c shellapi.Capability) error {
+	if r.granted[c] {
+		return nil
+	}
+	return fmt.Errorf("%w: module %s lacks the %s capability", shellapi.ErrCapabilityDenied, r.module, c)
+}
+
+// denied reports whether c is missing, warning about calls that cannot
+// return an error
+func (r *restrictedShell) denied(c shellapi.Capability) bool {
+	err := r.check(c)
+	if err != nil {
+		r.shell.Warn("%v", err)
+	}
+	return err != nil
+}
+
+// checkOwner fails unless the module may write state, as itself
+func (r *restrictedShell) checkOwner(owner string) error {
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return err
+	}
+	if owner != r.module {
+		return fmt.Errorf("%w: module %s cannot act as %s", shellapi.ErrCapabilityDenied, r.module, owner)
+	}
+	return nil
+}
+
+// Module management: switching modules and reaching into the command tree
+// need exec, and module profiles, saved to a file, filesystem too. Reading
+// about modules needs nothing.
+
+func (r *restrictedShell) EnableModule(moduleName string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.EnableModule(moduleName)
+}
+
+func (r *restrictedShell) DisableModule(moduleName string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.DisableModule(moduleName)
+}
+
+func (r *restrictedShell) IsModuleEnabled(moduleName string) bool {
+	return r.shell.IsModuleEnabled(moduleName)
+}
+
+func (r *restrictedShell) GetModules() []string {
+	return r.shell.GetModules()
+}
+
+func (r *restrictedShell) GetEnabledModules() []string {
+	return r.shell.GetEnabledModules()
+}
+
+func (r *restrictedShell) GetModuleMetadata(moduleName string) (shellapi.ModuleMetadata, bool) {
+	return r.shell.GetModuleMetadata(moduleName)
+}
+
+func (r *restrictedShell) DefineModuleGroup(name string, modules ...string) {
+	if !r.denied(shellapi.CapabilityExec) {
+		r.shell.DefineModuleGroup(name, modules...)
+	}
+}
+
+func (r *restrictedShell) ModuleGroups() []string {
+	return r.shell.ModuleGroups()
+}
+
+func (r *restrictedShell) EnableModuleGroup(name string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.EnableModuleGroup(name)
+}
+
+func (r *restrictedShell) DisableModuleGroup(name string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.DisableModuleGroup(name)
+}
+
+func (r *restrictedShell) UseModuleProfile(name string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	if err := r.check(shellapi.CapabilityFilesystem); err != nil {
+		return err
+	}
+	return r.shell.UseModuleProfile(name)
+}
+
+func (r *restrictedShell) ActiveModuleProfile() string {
+	return r.shell.ActiveModuleProfile()
+}
+
+func (r *restrictedShell) GetRootCmd() *cobra.Command {
+	if r.denied(shellapi.CapabilityExec) {
+		return nil
+	}
+	return r.shell.GetRootCmd()
+}
+
+func (r *restrictedShell) GetModuleCommands() map[string][]*cobra.Command {
+	if r.denied(shellapi.CapabilityExec) {
+		return nil
+	}
+	return r.shell.GetModuleCommands()
+}
+
+// Running commands needs exec. A module adds and removes commands of its
+// own only.
+
+func (r *restrictedShell) ExecuteCommand(command string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.ExecuteCommand(command)
+}
+
+func (r *restrictedShell) LastStatus() int {
+	return r.shell.LastStatus()
+}
+
+func (r *restrictedShell) ExecuteCommandContext(ctx context.Context, command string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.ExecuteCommandContext(ctx, command)
+}
+
+func (r *restrictedShell) ExecuteCommandCapture(command string) (string, string, error) {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return "", "", err
+	}
+	return r.shell.ExecuteCommandCapture(command)
+}
+
+func (r *restrictedShell) ClearCache() {
+	r.shell.ClearCache()
+}
+
+func (r *restrictedShell) RegisterCommands(moduleName string, cmds []*cobra.Command) error {
+	if moduleName != r.module {
+		return fmt.Errorf("%w: module %s cannot add commands to %s", shellapi.ErrCapabilityDenied, r.module, moduleName)
+	}
+	return r.shell.RegisterCommands(moduleName, cmds)
+}
+
+func (r *restrictedShell) UnregisterCommands(moduleName string, cmds []*cobra.Command) error {
+	if moduleName != r.module {
+		return fmt.Errorf("%w: module %s cannot remove commands of %s", shellapi.ErrCapabilityDenied, r.module, moduleName)
+	}
+	return r.shell.UnregisterCommands(moduleName, cmds)
+}
+
+// Scratch directories need filesystem, and a module only gets its own.
+// Clearing the history file and keeping the history from it need
+// filesystem too. Reading the history needs nothing.
+
+func (r *restrictedShell) TempDir(module string) (string, error) {
+	if err := r.check(shellapi.CapabilityFilesystem); err != nil {
+		return "", err
+	}
+	if module != r.module {
+		return "", fmt.Errorf("%w: module %s cannot use the scratch directory of %s", shellapi.ErrCapabilityDenied, r.module, module)
+	}
+	return r.shell.TempDir(module)
+}
+
+func (r *restrictedShell) History() []string {
+	return r.shell.History()
+}
+
+func (r *restrictedShell) HistoryRecords() []shellapi.HistoryRecord {
+	return r.shell.HistoryRecords()
+}
+
+func (r *restrictedShell) ClearHistory() error {
+	if err := r.check(shellapi.CapabilityFilesystem); err != nil {
+		return err
+	}
+	return r.shell.ClearHistory()
+}
+
+func (r *restrictedShell) RegisterHistoryExclusion(pattern string) error {
+	return r.shell.RegisterHistoryExclusion(pattern)
+}
+
+func (r *restrictedShell) SetHistoryInMemory(inMemory bool) {
+	if !r.denied(shellapi.CapabilityFilesystem) {
+		r.shell.SetHistoryInMemory(inMemory)
+	}
+}
+
+func (r *restrictedShell) HistoryInMemory() bool {
+	return r.shell.HistoryInMemory()
+}
+
+// Completion and reading the state need nothing. Changing the state needs
+// state-write, and its files filesystem too; a module protects state as
+// itself only.
+
+func (r *restrictedShell) RegisterCompleter(cmdPath string, fn func(prefix string) []string) {
+	r.shell.RegisterCompleter(cmdPath, fn)
+}
+
+func (r *restrictedShell) CompleteArg(cmdPath string, position int, fn func(prefix string) []string) {
+	r.shell.CompleteArg(cmdPath, position, fn)
+}
+
+func (r *restrictedShell) SetState(key string, value interface{}) error {
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return err
+	}
+	return r.shell.SetState(key, value)
+}
+
+func (r *restrictedShell) GetState(key string) (interface{}, bool) {
+	return r.shell.GetState(key)
+}
+
+func (r *restrictedShell) SetStateTTL(key string, value interface{}, ttl time.Duration) error {
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return err
+	}
+	return r.shell.SetStateTTL(key, value, ttl)
+}
+
+func (r *restrictedShell) OnStateExpire(key string, fn func(key string, value interface{})) {
+	r.shell.OnStateExpire(key, fn)
+}
+
+func (r *restrictedShell) UpdateState(key string, fn func(old interface{}) interface{}) error {
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return err
+	}
+	return r.shell.UpdateState(key, fn)
+}
+
+func (r *restrictedShell) CompareAndSwapState(key string, old, new interface{}) (bool, error) {
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return false, err
+	}
+	return r.shell.CompareAndSwapState(key, old, new)
+}
+
+func (r *restrictedShell) WatchState(key string, fn func(old, new interface{})) {
+	r.shell.WatchState(key, fn)
+}
+
+func (r *restrictedShell) StateKeys(prefix string) []string {
+	return r.shell.StateKeys(prefix)
+}
+
+func (r *restrictedShell) ListStateKeys() []string {
+	return r.shell.ListStateKeys()
+}
+
+func (r *restrictedShell) DeleteState(key string) (bool, error) {
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return false, err
+	}
+	return r.shell.DeleteState(key)
+}
+
+func (r *restrictedShell) ClearState() {
+	if !r.denied(shellapi.CapabilityStateWrite) {
+		r.shell.ClearState()
+	}
+}
+
+func (r *restrictedShell) SnapshotState() map[string]interface{} {
+	return r.shell.SnapshotState()
+}
+
+func (r *restrictedShell) RestoreState(snapshot map[string]interface{}) {
+	if !r.denied(shellapi.CapabilityStateWrite) {
+		r.shell.RestoreState(snapshot)
+	}
+}
+
+func (r *restrictedShell) RegisterStateDecoder(key string, decode func(data []byte) (interface{}, error)) {
+	if !r.denied(shellapi.CapabilityStateWrite) {
+		r.shell.RegisterStateDecoder(key, decode)
+	}
+}
+
+func (r *restrictedShell) StateStats() shellapi.StateStats {
+	return r.shell.StateStats()
+}
+
+func (r *restrictedShell) ProtectState(owner, key string) error {
+	if err := r.checkOwner(owner); err != nil {
+		return err
+	}
+	return r.shell.ProtectState(owner, key)
+}
+
+func (r *restrictedShell) UnprotectState(owner, key string) error {
+	if err := r.checkOwner(owner); err != nil {
+		return err
+	}
+	return r.shell.UnprotectState(owner, key)
+}
+
+func (r *restrictedShell) SetOwnedState(owner, key string, value interface{}) error {
+	if err := r.checkOwner(owner); err != nil {
+		return err
+	}
+	return r.shell.SetOwnedState(owner, key, value)
+}
+
+func (r *restrictedShell) StateOwner(key string) (string, bool) {
+	return r.shell.StateOwner(key)
+}
+
+func (r *restrictedShell) ExportState(path string) error {
+	if err := r.check(shellapi.CapabilityFilesystem); err != nil {
+		return err
+	}
+	return r.shell.ExportState(path)
+}
+
+func (r *restrictedShell) ImportState(path string) error {
+	if err := r.check(shellapi.CapabilityFilesystem); err != nil {
+		return err
+	}
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return err
+	}
+	return r.shell.ImportState(path)
+}
+
+func (r *restrictedShell) SetGlobalState(key string, value interface{}) {
+	if !r.denied(shellapi.CapabilityStateWrite) {
+		r.shell.SetGlobalState(key, value)
+	}
+}
+
+func (r *restrictedShell) GetGlobalState(key string) (interface{}, bool) {
+	return r.shell.GetGlobalState(key)
+}
+
+func (r *restrictedShell) DeleteGlobalState(key string) bool {
+	if r.denied(shellapi.CapabilityStateWrite) {
+		return false
+	}
+	return r.shell.DeleteGlobalState(key)
+}
+
+func (r *restrictedShell) GlobalStateKeys(prefix string) []string {
+	return r.shell.GlobalStateKeys(prefix)
+}
+
+func (r *restrictedShell) ExportProfile(path string) error {
+	if err := r.check(shellapi.CapabilityFilesystem); err != nil {
+		return err
+	}
+	return r.shell.ExportProfile(path)
+}
+
+func (r *restrictedShell) ImportProfile(path string) error {
+	if err := r.check(shellapi.CapabilityFilesystem); err != nil {
+		return err
+	}
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return err
+	}
+	return r.shell.ImportProfile(path)
+}
+
+// Output and prompts need nothing, but for ForEachSelected, which runs
+// commands.
+
+func (r *restrictedShell) SetPrompt(prompt string) {
+	r.shell.SetPrompt(prompt)
+}
+
+func (r *restrictedShell) GetPrompt() string {
+	return r.shell.GetPrompt()
+}
+
+func (r *restrictedShell) SetRightPrompt(text string) {
+	r.shell.SetRightPrompt(text)
+}
+
+func (r *restrictedShell) GetRightPrompt() string {
+	return r.shell.GetRightPrompt()
+}
+
+func (r *restrictedShell) SetStatus(text string) {
+	r.shell.SetStatus(text)
+}
+
+func (r *restrictedShell) GetStatus() string {
+	return r.shell.GetStatus()
+}
+
+func (r *restrictedShell) ReprintBanner() {
+	r.shell.ReprintBanner()
+}
+
+func (r *restrictedShell) ClearScreen() {
+	r.shell.ClearScreen()
+}
+
+func (r *restrictedShell) Interactive() bool {
+	return r.shell.Interactive()
+}
+
+func (r *restrictedShell) PrintAlert(message string) {
+	r.shell.PrintAlert(message)
+}
+
+func (r *restrictedShell) Confirm(question string, defaultYes bool) bool {
+	return r.shell.Confirm(question, defaultYes)
+}
+
+func (r *restrictedShell) ReadSecret(prompt string) (string, error) {
+	return r.shell.ReadSecret(prompt)
+}
+
+func (r *restrictedShell) Select(label string, options []string) (string, error) {
+	return r.shell.Select(label, options)
+}
+
+func (r *restrictedShell) MultiSelect(label string, options []string) ([]string, error) {
+	return r.shell.MultiSelect(label, options)
+}
+
+func (r *restrictedShell) ForEachSelected(label string, options []string, command string) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.ForEachSelected(label, options, command)
+}
+
+func (r *restrictedShell) RequestRefresh() {
+	r.shell.RequestRefresh()
+}
+
+func (r *restrictedShell) NewProgressBar(total int) shellapi.ProgressBar {
+	return r.shell.NewProgressBar(total)
+}
+
+func (r *restrictedShell) NewSpinner(label string) shellapi.Spinner {
+	return r.shell.NewSpinner(label)
+}
+
+func (r *restrictedShell) Width() int {
+	return r.shell.Width()
+}
+
+func (r *restrictedShell) Height() int {
+	return r.shell.Height()
+}
+
+func (r *restrictedShell) ColorEnabled() bool {
+	return r.shell.ColorEnabled()
+}
+
+func (r *restrictedShell) OnResize(fn func(width, height int)) {
+	r.shell.OnResize(fn)
+}
+
+func (r *restrictedShell) Table(headers []string, rows [][]string) {
+	r.shell.Table(headers, rows)
+}
+
+func (r *restrictedShell) Wrap(text string, width int) string {
+	return r.shell.Wrap(text, width)
+}
+
+func (r *restrictedShell) Truncate(text string, width int) string {
+	return r.shell.Truncate(text, width)
+}
+
+func (r *restrictedShell) Stdin() io.Reader {
+	return r.shell.Stdin()
+}
+
+func (r *restrictedShell) Stdout() io.Writer {
+	return r.shell.Stdout()
+}
+
+func (r *restrictedShell) Stderr() io.Writer {
+	return r.shell.Stderr()
+}
+
+func (r *restrictedShell) Info(format string, args ...interface{}) {
+	r.shell.Info(format, args...)
+}
+
+func (r *restrictedShell) Success(format string, args ...interface{}) {
+	r.shell.Success(format, args...)
+}
+
+func (r *restrictedShell) Warn(format string, args ...interface{}) {
+	r.shell.Warn(format, args...)
+}
+
+func (r *restrictedShell) Error(format string, args ...interface{}) {
+	r.shell.Error(format, args...)
+}
+
+// Transactions undo what running commands registered, and need nothing.
+
+func (r *restrictedShell) BeginTransaction() error {
+	return r.shell.BeginTransaction()
+}
+
+func (r *restrictedShell) CommitTransaction() error {
+	return r.shell.CommitTransaction()
+}
+
+func (r *restrictedShell) AbortTransaction() error {
+	return r.shell.AbortTransaction()
+}
+
+func (r *restrictedShell) InTransaction() bool {
+	return r.shell.InTransaction()
+}
+
+func (r *restrictedShell) RegisterUndo(description string, undo func() error) {
+	r.shell.RegisterUndo(description, undo)
+}
+
+// Hooks seeing or changing every line and command need exec, as do
+// managing jobs and ending the shell. Running something before the prompt
+// or at exit needs nothing.
+
+func (r *restrictedShell) BeforeReadline(fn func()) {
+	r.shell.BeforeReadline(fn)
+}
+
+func (r *restrictedShell) AfterReadline(fn func(line string) string) {
+	if !r.denied(shellapi.CapabilityExec) {
+		r.shell.AfterReadline(fn)
+	}
+}
+
+func (r *restrictedShell) BeforeExecute(fn func(line string) error) {
+	if !r.denied(shellapi.CapabilityExec) {
+		r.shell.BeforeExecute(fn)
+	}
+}
+
+func (r *restrictedShell) AfterExecute(fn func(line string, err error)) {
+	if !r.denied(shellapi.CapabilityExec) {
+		r.shell.AfterExecute(fn)
+	}
+}
+
+func (r *restrictedShell) Use(middleware ...shellapi.Middleware) {
+	if !r.denied(shellapi.CapabilityExec) {
+		r.shell.Use(middleware...)
+	}
+}
+
+func (r *restrictedShell) Jobs() []shellapi.Job {
+	return r.shell.Jobs()
+}
+
+func (r *restrictedShell) ForegroundJob(ctx context.Context, id int) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.ForegroundJob(ctx, id)
+}
+
+func (r *restrictedShell) KillJob(id int) error {
+	if err := r.check(shellapi.CapabilityExec); err != nil {
+		return err
+	}
+	return r.shell.KillJob(id)
+}
+
+func (r *restrictedShell) OnExit(fn func()) {
+	r.shell.OnExit(fn)
+}
+
+func (r *restrictedShell) Exit(code int) {
+	if !r.denied(shellapi.CapabilityExec) {
+		r.shell.Exit(code)
+	}
+}
+
+// Registering health checks, redaction, features and settings needs
+// nothing; changing features and settings needs state-write.
+
+func (r *restrictedShell) RegisterHealthCheck(name string, check func() error) {
+	r.shell.RegisterHealthCheck(name, check)
+}
+
+func (r *restrictedShell) RunHealthChecks() []shellapi.HealthResult {
+	return r.shell.RunHealthChecks()
+}
+
+func (r *restrictedShell) ModuleHealth() []shellapi.ModuleHealth {
+	return r.shell.ModuleHealth()
+}
+
+func (r *restrictedShell) RegisterRedaction(pattern string) error {
+	return r.shell.RegisterRedaction(pattern)
+}
+
+func (r *restrictedShell) RegisterRedactor(fn func(text string) string) {
+	r.shell.RegisterRedactor(fn)
+}
+
+func (r *restrictedShell) Redact(text string) string {
+	return r.shell.Redact(text)
+}
+
+func (r *restrictedShell) RegisterFeature(name, description string) {
+	r.shell.RegisterFeature(name, description)
+}
+
+func (r *restrictedShell) FeatureEnabled(name string) bool {
+	return r.shell.FeatureEnabled(name)
+}
+
+func (r *restrictedShell) SetFeature(name string, enabled bool) {
+	if !r.denied(shellapi.CapabilityStateWrite) {
+		r.shell.SetFeature(name, enabled)
+	}
+}
+
+func (r *restrictedShell) Features() []shellapi.Feature {
+	return r.shell.Features()
+}
+
+func (r *restrictedShell) RegisterSetting(name, description, value string, apply func(value string) error) error {
+	return r.shell.RegisterSetting(name, description, value, apply)
+}
+
+func (r *restrictedShell) SetSetting(name, value string) error {
+	if err := r.check(shellapi.CapabilityStateWrite); err != nil {
+		return err
+	}
+	return r.shell.SetSetting(name, value)
+}
+
+func (r *restrictedShell) GetSetting(name string) (string, bool) {
+	return r.shell.GetSetting(name)
+}
+
+func (r *restrictedShell) GetSettings() []shellapi.Setting {
+	return r.shell.GetSettings()
+}
+
+// Ensure restrictedShell implements ShellAPI
+var _ shellapi.ShellAPI = (*restrictedShell)(nil)
@@ -0,0 +1,79 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sync"
+)
+
+// ExecuteCommandCapture runs a command like ExecuteCommand, collecting what
+// it writes to stdout and stderr instead of printing it
+func (s *Shell) ExecuteCommandCapture(command string) (stdout, stderr string, err error) {
+	ctx, unlock := s.lockExecution(s.commandContext())
+	defer unlock()
+	stopStdout := s.startCapture(&s.outCaptures, nil)
+	stopStderr := s.startCapture(&s.errCaptures, nil)
+	err = s.withContext(ctx, func() error { return s.executeLine(command, nil) })
+	return stopStdout(), stopStderr(), err
+}
+
+// captureOutput runs fn with stdout copied into a buffer, and returns what
+// was written. With echo the output still goes through to stdout.
+func (s *Shell) captureOutput(fn func() error, echo bool) (string, error) {
+	var previous io.Writer
+	if echo {
+		previous = s.Stdout()
+	}
+	stop := s.startCapture(&s.outCaptures, previous)
+	err := fn()
+	return stop(), err
+}
+
+// capture collects what is written to a stream while a command's output
+// is captured, passing it on to echo if set
+type capture struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	echo io.Writer
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf.Write(p)
+	if c.echo != nil {
+		return c.echo.Write(p)
+	}
+	return len(p), nil
+}
+
+// startCapture makes a new capture the innermost of captures, which Stdout
+// or Stderr return while it lasts. The returned function ends it and
+// returns the text.
+func (s *Shell) startCapture(captures *[]*capture, echo io.Writer) func() string {
+	c := &capture{echo: echo}
+	s.captureMutex.Lock()
+	*captures = append(*captures, c)
+	s.captureMutex.Unlock()
+	return func() string {
+		s.captureMutex.Lock()
+		if i := slices.Index(*captures, c); i >= 0 {
+			*captures = slices.Delete(*captures, i, i+1)
+		}
+		s.captureMutex.Unlock()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.buf.String()
+	}
+}
+
+// capturing returns the innermost of captures, or nil if there is none
+func (s *Shell) capturing(captures *[]*capture) io.Writer {
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+	if len(*captures) == 0 {
+		return nil
+	}
+	return (*captures)[len(*captures)-1]
+}
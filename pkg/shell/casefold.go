@@ -0,0 +1,112 @@
+package shell
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// CompletionCase controls how letter case is compared in tab completion
+type CompletionCase int32
+
+const (
+	// CaseSensitive matches the typed word's case exactly
+	CaseSensitive CompletionCase = iota
+	// CaseInsensitive ignores case, so `EN` completes `enable`
+	CaseInsensitive
+	// SmartCase ignores case unless the typed word contains an uppercase letter
+	SmartCase
+)
+
+// caseNames maps setting values to completion case modes
+var caseNames = map[string]CompletionCase{
+	"sensitive":   CaseSensitive,
+	"insensitive": CaseInsensitive,
+	"smart":       SmartCase,
+}
+
+// ParseCompletionCase converts a mode name such as "smart" to a CompletionCase
+func ParseCompletionCase(name string) (CompletionCase, error) {
+	mode, ok := caseNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown completion case: %s (expected sensitive, insensitive or smart)", name)
+	}
+	return mode, nil
+}
+
+// String returns the setting value for the mode
+func (c CompletionCase) String() string {
+	for name, mode := range caseNames {
+		if mode == c {
+			return name
+		}
+	}
+	return "sensitive"
+}
+
+// ignoresCase reports whether word should be matched ignoring case
+func (c CompletionCase) ignoresCase(word string) bool {
+	switch c {
+	case CaseInsensitive:
+		return true
+	case SmartCase:
+		return strings.IndexFunc(word, unicode.IsUpper) < 0
+	}
+	return false
+}
+
+// caseFoldComplete completes the word under the cursor ignoring case when
+// the completion-case setting allows it. A single match replaces the word;
+// several are extended to their common prefix and listed.
+func (s *Shell) caseFoldComplete(line []rune, pos int) ([]rune, int, bool, bool) {
+	start := pos
+	for start > 0 && line[start-1] != ' ' {
+		start--
+	}
+	word := string(line[start:pos])
+	if word == "" || !CompletionCase(s.completionCase.Load()).ignoresCase(word) {
+		return nil, 0, false, false
+	}
+
+	// With the word removed the tree offers everything valid at this position
+	options, _ := s.completer.backend.Do(line[:start], start)
+	matches := []string{}
+	for _, option := range options {
+		name := strings.TrimRight(string(option), " ")
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(word)) {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, 0, false, false
+	}
+
+	replacement := matches[0]
+	if len(matches) == 1 {
+		if !strings.HasSuffix(replacement, string(filepath.Separator)) {
+			replacement += " "
+		}
+	} else {
+		for _, m := range matches[1:] {
+			replacement = commonFoldedPrefix(replacement, m)
+		}
+		var b strings.Builder
+		for _, m := range matches {
+			b.WriteString("  " + m + "\n")
+		}
+		s.rl.Write([]byte(b.String()))
+	}
+	newLine := append(append(append([]rune{}, line[:start]...), []rune(replacement)...), line[pos:]...)
+	return newLine, start + len([]rune(replacement)), true, true
+}
+
+// commonFoldedPrefix returns the longest prefix of a shared with b, ignoring case
+func commonFoldedPrefix(a, b string) string {
+	ar, br := []rune(a), []rune(b)
+	n := 0
+	for n < len(ar) && n < len(br) && unicode.ToLower(ar[n]) == unicode.ToLower(br[n]) {
+		n++
+	}
+	return string(ar[:n])
+}
@@ -0,0 +1,641 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// Completer produces tab completion candidates for the input line. It
+// returns the suffixes that can follow the cursor and the length of the
+// word they complete, the same contract as readline.AutoCompleter.
+type Completer interface {
+	Do(line []rune, pos int) (newLine [][]rune, length int)
+}
+
+// SetCompleter replaces the completion backend. Passing nil restores the
+// completer built from the command tree, which remains available through
+// CommandCompleter for backends that want to delegate to it.
+func (s *Shell) SetCompleter(c Completer) {
+	s.customCompleter = c
+	s.installCompleter()
+}
+
+// CommandCompleter returns the completer built from the command tree
+func (s *Shell) CommandCompleter() Completer {
+	return s.commandTree
+}
+
+// installCompleter hands the completion backend to readline
+func (s *Shell) installCompleter() {
+	var backend Completer = s.commandTree
+	if s.customCompleter != nil {
+		backend = s.customCompleter
+	}
+	s.completer = &treeCompleter{backend: backend, shell: s}
+	s.rl.Config.AutoComplete = s.completer
+}
+
+// addCompleterNodes adds or rebuilds the completion nodes of top-level
+// commands, leaving the rest of the tree untouched
+func (s *Shell) addCompleterNodes(cmds ...*cobra.Command) {
+	s.removeCompleterNodes(cmds...)
+	for _, cmd := range cmds {
+		if cmd.Hidden {
+			continue
+		}
+		nodes := commandNodes(s.commandCompleter(cmd, 1), cmd)
+		s.completerNodes[cmd] = nodes
+		s.commandTree.Children = append(s.commandTree.Children, nodes...)
+	}
+
+	// Keep the tree ordered like cobra's command list
+	sort.SliceStable(s.commandTree.Children, func(i, j int) bool {
+		return string(s.commandTree.Children[i].GetName()) < string(s.commandTree.Children[j].GetName())
+	})
+}
+
+// removeCompleterNodes drops the completion nodes of top-level commands
+func (s *Shell) removeCompleterNodes(cmds ...*cobra.Command) {
+	removed := make(map[readline.PrefixCompleterInterface]bool)
+	for _, cmd := range cmds {
+		if nodes, ok := s.completerNodes[cmd]; ok {
+			for _, node := range nodes {
+				removed[node] = true
+			}
+			delete(s.completerNodes, cmd)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	children := s.commandTree.Children[:0]
+	for _, child := range s.commandTree.Children {
+		if !removed[child] {
+			children = append(children, child)
+		}
+	}
+	s.commandTree.Children = children
+}
+
+// treeCompleter wraps the completion backend and remembers whether the
+// last tab press found anything, so fuzzy matching can take over
+type treeCompleter struct {
+	backend Completer
+	shell   *Shell
+	missed  bool
+}
+
+func (c *treeCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	if c.shell.menu != nil {
+		// The listener cycles the open menu
+		return nil, 0
+	}
+	candidates, offset := c.backend.Do(line, pos)
+	c.missed = len(candidates) == 0
+	if len(candidates) > 1 && c.shell.menuCompletion.Load() {
+		// Hide the candidates from readline's grid and show the menu instead
+		c.shell.openMenu(line, pos, candidates, offset)
+		return nil, 0
+	}
+	return candidates, offset
+}
+
+// onKey is the readline listener, called after every key press
+func (s *Shell) onKey(line []rune, pos int, key rune) ([]rune, int, bool) {
+	if s.asking {
+		return nil, 0, false
+	}
+
+	// A second tab on an unchanged line lists the candidates with descriptions
+	secondTab := key == readline.CharTab && s.lastKey == readline.CharTab && string(line) == s.lastLine
+	s.lastKey, s.lastLine = key, string(line)
+	if s.menu != nil {
+		return s.menuKey(line, key)
+	}
+	if key == readline.CharForward {
+		return s.acceptSuggestion(line, pos)
+	}
+	if key != readline.CharTab || s.completer == nil {
+		return nil, 0, false
+	}
+	if s.completer.missed {
+		if newLine, newPos, ok, handled := s.caseFoldComplete(line, pos); handled {
+			return newLine, newPos, ok
+		}
+	}
+	if s.fuzzyCompletion.Load() && s.completer.missed {
+		return s.fuzzyComplete(line, pos)
+	}
+	if secondTab {
+		s.describeCompletions(line, pos)
+	}
+	return nil, 0, false
+}
+
+// describeCompletions prints the candidates for the word under the cursor
+// next to their descriptions
+func (s *Shell) describeCompletions(line []rune, pos int) {
+	candidates, offset := s.completer.backend.Do(line, pos)
+	if len(candidates) < 2 {
+		return
+	}
+	word := string(line[pos-offset : pos])
+	cmd, depth := s.completionCommand(string(line[:pos]))
+	descriptions := completionDescriptions(cmd, string(line[:pos]), depth)
+
+	var b strings.Builder
+	for _, candidate := range candidates {
+		name := strings.TrimRight(word+string(candidate), " ")
+		fmt.Fprintf(&b, "  %-20s %s\n", name, descriptions[name])
+	}
+	s.rl.Write([]byte(b.String()))
+}
+
+// completionCommand finds the command named by the complete words on the
+// line and returns it with the number of words naming it
+func (s *Shell) completionCommand(line string) (*cobra.Command, int) {
+	fields := strings.Fields(line)
+	if !strings.HasSuffix(line, " ") && len(fields) > 0 {
+		fields = fields[:len(fields)-1]
+	}
+	cmd := s.rootCmd
+	depth := 0
+	for _, field := range fields {
+		child := findSubcommand(cmd, field)
+		if child == nil {
+			break
+		}
+		cmd = child
+		depth++
+	}
+	return cmd, depth
+}
+
+// completionDescriptions maps everything that can follow cmd on the line
+// to a description: subcommands, flags and described ValidArgs
+func completionDescriptions(cmd *cobra.Command, line string, depth int) map[string]string {
+	descriptions := make(map[string]string)
+	for _, child := range cmd.Commands() {
+		descriptions[child.Name()] = child.Short
+	}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		descriptions["--"+f.Name] = f.Usage
+		if f.Shorthand != "" {
+			descriptions["-"+f.Shorthand] = f.Usage
+		}
+	})
+	cmd.InheritedFlags().VisitAll(func(f *pflag.Flag) {
+		descriptions["--"+f.Name] = f.Usage
+	})
+
+	completions := cmd.ValidArgs
+	if cmd.ValidArgsFunction != nil {
+		args, toComplete := splitCompletionLine(cmd, line, depth)
+		completions, _ = cmd.ValidArgsFunction(cmd, args, toComplete)
+	}
+	for _, completion := range completions {
+		if name, description, ok := strings.Cut(completion, "\t"); ok {
+			descriptions[name] = description
+		}
+	}
+	return descriptions
+}
+
+// fuzzyComplete replaces the word under the cursor with its only fuzzy
+// match, or lists the matches with the matched characters highlighted
+func (s *Shell) fuzzyComplete(line []rune, pos int) ([]rune, int, bool) {
+	start := pos
+	for start > 0 && line[start-1] != ' ' {
+		start--
+	}
+	word := string(line[start:pos])
+	if word == "" {
+		return nil, 0, false
+	}
+
+	// With the word removed the tree offers everything valid at this position
+	options, _ := s.completer.backend.Do(line[:start], start)
+	type match struct {
+		name  string
+		score int
+	}
+	matches := []match{}
+	for _, option := range options {
+		name := strings.TrimRight(string(option), " ")
+		if score, ok := fuzzyMatch(word, name); ok {
+			matches = append(matches, match{name, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	switch len(matches) {
+	case 0:
+		return nil, 0, false
+	case 1:
+		name := matches[0].name
+		if !strings.HasSuffix(name, string(filepath.Separator)) {
+			name += " "
+		}
+		newLine := append(append(append([]rune{}, line[:start]...), []rune(name)...), line[pos:]...)
+		return newLine, start + len([]rune(name)), true
+	}
+
+	var b strings.Builder
+	for _, m := range matches {
+		name := m.name
+		if s.ColorEnabled() {
+			name = fuzzyHighlight(word, name)
+		}
+		b.WriteString("  " + name + "\n")
+	}
+	s.rl.Write([]byte(b.String()))
+	return nil, 0, false
+}
+
+// RegisterCompleter supplies runtime completions for the arguments of the
+// command at cmdPath (e.g. "connect" or "server start"). The function gets
+// the word being typed and returns the candidates.
+func (s *Shell) RegisterCompleter(cmdPath string, fn func(prefix string) []string) {
+	s.completersMutex.Lock()
+	s.completers[cmdPath] = fn
+	s.completersMutex.Unlock()
+	s.rebuildCompleterFor(cmdPath)
+}
+
+// CompleteArg supplies runtime completions for a single positional argument
+// of the command at cmdPath, counted from zero, so each argument of a
+// multi-argument command completes from its own list. Positions without a
+// function fall back to RegisterCompleter or the command's ValidArgs.
+func (s *Shell) CompleteArg(cmdPath string, position int, fn func(prefix string) []string) {
+	s.completersMutex.Lock()
+	if s.argCompleters[cmdPath] == nil {
+		s.argCompleters[cmdPath] = make(map[int]func(prefix string) []string)
+	}
+	s.argCompleters[cmdPath][position] = fn
+	s.completersMutex.Unlock()
+	s.rebuildCompleterFor(cmdPath)
+}
+
+// rebuildCompleterFor rebuilds the completion tree of the top-level command
+// containing cmdPath; the rest of the tree is unaffected
+func (s *Shell) rebuildCompleterFor(cmdPath string) {
+	if name, _, _ := strings.Cut(strings.TrimSpace(cmdPath), " "); name != "" {
+		if cmd := findSubcommand(s.rootCmd, name); cmd != nil {
+			s.addCompleterNodes(cmd)
+		}
+	}
+}
+
+// registeredCompleter returns the completions registered for a command,
+// falling back to fallback for positions nothing was registered for
+func (s *Shell) registeredCompleter(cmd *cobra.Command, fallback argCandidates) argCandidates {
+	path := s.commandPath(cmd)
+	s.completersMutex.RLock()
+	whole := s.completers[path]
+	positions := s.argCompleters[path]
+	s.completersMutex.RUnlock()
+	if whole == nil && positions == nil {
+		return fallback
+	}
+	return func(args []string, toComplete string) []string {
+		if fn, ok := positions[len(args)]; ok {
+			return fn(toComplete)
+		}
+		if whole != nil {
+			return whole(toComplete)
+		}
+		if fallback != nil {
+			return fallback(args, toComplete)
+		}
+		return nil
+	}
+}
+
+// commandPath returns the words naming cmd below the root command
+func (s *Shell) commandPath(cmd *cobra.Command) string {
+	return strings.TrimPrefix(cmd.CommandPath(), s.rootCmd.Name()+" ")
+}
+
+// subcommandCompleters builds completion nodes for the visible children of
+// cmd, where depth is the number of words naming a child on the line
+func (s *Shell) subcommandCompleters(cmd *cobra.Command, depth int) []readline.PrefixCompleterInterface {
+	items := []readline.PrefixCompleterInterface{}
+	for _, child := range cmd.Commands() {
+		if child.Hidden {
+			continue
+		}
+		items = append(items, commandNodes(s.commandCompleter(child, depth), child)...)
+	}
+	return items
+}
+
+// commandNodes returns the completion node of a command followed by one per
+// alias, sharing what may follow the name
+func commandNodes(node *readline.PrefixCompleter, cmd *cobra.Command) []readline.PrefixCompleterInterface {
+	nodes := []readline.PrefixCompleterInterface{node}
+	for _, alias := range cmd.Aliases {
+		nodes = append(nodes, readline.PcItem(alias, node.Children...))
+	}
+	return nodes
+}
+
+// commandCompleter builds the completion node for a command, its
+// subcommands, its flags and its positional arguments
+func (s *Shell) commandCompleter(cmd *cobra.Command, depth int) *readline.PrefixCompleter {
+	// next holds what may follow the command, a flag or an argument
+	next := []readline.PrefixCompleterInterface{}
+	chained := []readline.PrefixCompleterInterface{}
+	add := func(f *pflag.Flag) {
+		if f.Hidden {
+			return
+		}
+		names := []string{"--" + f.Name}
+		if f.Shorthand != "" {
+			names = append(names, "-"+f.Shorthand)
+		}
+		for _, name := range names {
+			item := readline.PcItem(name)
+			if f.NoOptDefVal == "" {
+				// The flag takes a value, skip over it before offering flags again
+				values := typedValues(name)
+				if _, ok := f.Annotations[shellapi.AnnotationStateKeyArgs]; ok {
+					values = s.withStateKeyValues(values)
+				}
+				value := readline.PcItemDynamic(values)
+				item.Children = []readline.PrefixCompleterInterface{value}
+				chained = append(chained, value)
+			} else {
+				chained = append(chained, item)
+			}
+			next = append(next, item)
+		}
+	}
+	cmd.LocalFlags().VisitAll(add)
+	cmd.InheritedFlags().VisitAll(add)
+
+	// Module-registered completers take precedence over cobra's ValidArgs
+	var candidates argCandidates
+	if cmd.ValidArgsFunction != nil || len(cmd.ValidArgs) > 0 {
+		candidates = validArgs(cmd)
+	}
+	candidates = s.registeredCompleter(cmd, candidates)
+	if _, ok := cmd.Annotations[shellapi.AnnotationFileArgs]; ok {
+		candidates = withFilePaths(cmd, candidates)
+	}
+	if _, ok := cmd.Annotations[shellapi.AnnotationStateKeyArgs]; ok {
+		candidates = s.withStateKeys(cmd, candidates)
+	}
+	if candidates != nil {
+		args := argCompleter(cmd, depth, candidates)
+		next = append(next, args)
+		chained = append(chained, args)
+	}
+
+	// Everything that completes a word offers the full list again, so
+	// several flags and arguments complete in a row
+	for _, item := range chained {
+		item.SetChildren(next)
+	}
+
+	// Subcommands can only follow the command name directly
+	children := append(s.subcommandCompleters(cmd, depth+1), next...)
+	return readline.PcItem(cmd.Name(), children...)
+}
+
+// argCandidates returns completions for the argument being typed given the
+// complete arguments before it
+type argCandidates func(args []string, toComplete string) []string
+
+// dynamicItem is a completion node whose names are computed from the line.
+// Unlike readline's PcItemDynamic it leaves names ending in a path separator
+// without a trailing space, so paths complete one directory at a time.
+type dynamicItem struct {
+	readline.PrefixCompleter
+	names func(line string) []string
+}
+
+func (d *dynamicItem) IsDynamic() bool {
+	return true
+}
+
+func (d *dynamicItem) GetDynamicNames(line []rune) [][]rune {
+	names := [][]rune{}
+	for _, name := range d.names(string(line)) {
+		if !strings.HasSuffix(name, string(filepath.Separator)) {
+			name += " "
+		}
+		names = append(names, []rune(name))
+	}
+	return names
+}
+
+// argCompleter returns a dynamic node completing positional arguments of cmd,
+// where depth is the number of words naming the command on the line
+func argCompleter(cmd *cobra.Command, depth int, candidates argCandidates) *dynamicItem {
+	return &dynamicItem{names: func(line string) []string {
+		args, toComplete := splitCompletionLine(cmd, line, depth)
+		// Arguments already typed are accepted as-is so completion can move past them
+		return unique(append(candidates(args, toComplete), args...))
+	}}
+}
+
+// unique removes duplicate names, keeping the first occurrence
+func unique(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	result := names[:0]
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// validArgs completes from the command's ValidArgs or ValidArgsFunction
+func validArgs(cmd *cobra.Command) argCandidates {
+	return func(args []string, toComplete string) []string {
+		completions := cmd.ValidArgs
+		if cmd.ValidArgsFunction != nil {
+			var directive cobra.ShellCompDirective
+			completions, directive = cmd.ValidArgsFunction(cmd, args, toComplete)
+			if directive&cobra.ShellCompDirectiveError != 0 {
+				return nil
+			}
+		}
+
+		names := make([]string, 0, len(completions))
+		for _, completion := range completions {
+			// Drop cobra's tab separated descriptions
+			name, _, _ := strings.Cut(completion, "\t")
+			names = append(names, name)
+		}
+		return names
+	}
+}
+
+// withFilePaths adds file path completion to the argument positions listed
+// in the command's shellapi.AnnotationFileArgs annotation
+func withFilePaths(cmd *cobra.Command, candidates argCandidates) argCandidates {
+	return func(args []string, toComplete string) []string {
+		names := []string{}
+		if candidates != nil {
+			names = candidates(args, toComplete)
+		}
+		if isAnnotatedArg(cmd, shellapi.AnnotationFileArgs, len(args)) {
+			names = append(names, filePathCandidates(toComplete)...)
+		}
+		return names
+	}
+}
+
+// withStateKeys adds shared state key completion to the argument positions
+// listed in the command's shellapi.AnnotationStateKeyArgs annotation
+func (s *Shell) withStateKeys(cmd *cobra.Command, candidates argCandidates) argCandidates {
+	return func(args []string, toComplete string) []string {
+		names := []string{}
+		if candidates != nil {
+			names = candidates(args, toComplete)
+		}
+		if isAnnotatedArg(cmd, shellapi.AnnotationStateKeyArgs, len(args)) {
+			names = append(names, s.StateKeys(toComplete)...)
+		}
+		return names
+	}
+}
+
+// withStateKeyValues offers shared state keys as the value of a flag
+func (s *Shell) withStateKeyValues(values readline.DynamicCompleteFunc) readline.DynamicCompleteFunc {
+	return func(line string) []string {
+		return unique(append(s.StateKeys(""), values(line)...))
+	}
+}
+
+// isAnnotatedArg reports whether the argument at position is listed in the
+// command's annotation, a comma separated list of positions or "*"
+func isAnnotatedArg(cmd *cobra.Command, annotation string, position int) bool {
+	for _, field := range strings.Split(cmd.Annotations[annotation], ",") {
+		field = strings.TrimSpace(field)
+		if field == "*" || field == strconv.Itoa(position) {
+			return true
+		}
+	}
+	return false
+}
+
+// filePathCandidates lists the files and directories matching a partial
+// path. Directories end with a separator and ~ expands to the home directory.
+func filePathCandidates(toComplete string) []string {
+	if toComplete == "~" {
+		return []string{"~" + string(filepath.Separator)}
+	}
+
+	dir, base := filepath.Split(toComplete)
+	searchDir := expandHome(dir)
+	if searchDir == "" {
+		searchDir = "."
+	}
+	entries, err := os.ReadDir(searchDir)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		// Hidden files only show up once the user types the dot
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		path := dir + name
+		if info, err := os.Stat(filepath.Join(searchDir, name)); err == nil && info.IsDir() {
+			path += string(filepath.Separator)
+		}
+		names = append(names, path)
+	}
+	return names
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return home + path[1:]
+}
+
+// splitCompletionLine returns the complete positional arguments on the line
+// after the command words, and the partial word under the cursor
+func splitCompletionLine(cmd *cobra.Command, line string, depth int) ([]string, string) {
+	fields := strings.Fields(line)
+	toComplete := ""
+	if len(fields) > 0 && !strings.HasSuffix(line, " ") {
+		toComplete = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < depth {
+		return nil, toComplete
+	}
+
+	args := []string{}
+	words := fields[depth:]
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if !strings.HasPrefix(word, "-") {
+			args = append(args, word)
+			continue
+		}
+		// Skip the value of flags that take one
+		if name, _, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "="); !hasValue {
+			if f := lookupFlag(cmd, name, !strings.HasPrefix(word, "--")); f != nil && f.NoOptDefVal == "" {
+				i++
+			}
+		}
+	}
+	return args, toComplete
+}
+
+// lookupFlag finds a flag of cmd by name or shorthand
+func lookupFlag(cmd *cobra.Command, name string, shorthand bool) *pflag.Flag {
+	if shorthand {
+		return cmd.Flags().ShorthandLookup(name)
+	}
+	return cmd.Flags().Lookup(name)
+}
+
+// typedValues returns the words typed after flag on the line, so a flag's
+// value is accepted as-is by the completer
+func typedValues(flag string) readline.DynamicCompleteFunc {
+	return func(line string) []string {
+		values := []string{}
+		fields := strings.Fields(line)
+		if !strings.HasSuffix(line, " ") && len(fields) > 0 {
+			// The word under the cursor is still being typed
+			fields = fields[:len(fields)-1]
+		}
+		for i := 0; i < len(fields)-1; i++ {
+			if fields[i] == flag {
+				values = append(values, fields[i+1])
+			}
+		}
+		return values
+	}
+}
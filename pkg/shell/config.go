@@ -0,0 +1,50 @@
+package shell
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/module"
+)
+
+// configFile is the on-disk form of the shell's config file. Each module
+// gets the section under its name.
+type configFile struct {
+	Modules map[string]map[string]interface{} `json:"modules"`
+}
+
+// loadConfig reads the config file set with WithConfigFile
+func (s *Shell) loadConfig() error {
+	if s.configPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.configPath)
+	if err != nil {
+		return err
+	}
+	var config configFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", s.configPath, err)
+	}
+	s.moduleConfig = config.Modules
+	return nil
+}
+
+// ModuleConfig returns the section of the config file named name, empty
+// if it has none
+func (s *Shell) ModuleConfig(name string) map[string]interface{} {
+	cfg := s.moduleConfig[name]
+	if cfg == nil {
+		cfg = make(map[string]interface{})
+	}
+	return cfg
+}
+
+// configureModule hands a module implementing module.Configurable its
+// section of the config file
+func (s *Shell) configureModule(mod module.CommandModule) {
+	if configurable, ok := mod.(module.Configurable); ok {
+		configurable.Configure(s.ModuleConfig(mod.Name()))
+	}
+}
@@ -0,0 +1,196 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ConflictPolicy decides what happens when a module registers a command
+// whose name or alias another module already uses
+type ConflictPolicy int
+
+const (
+	// ConflictReject refuses the registration with an error naming the
+	// modules that clash. This is the default.
+	ConflictReject ConflictPolicy = iota
+	// ConflictPrefix registers the new command as <module>-<name>, and
+	// drops its aliases that clash
+	ConflictPrefix
+	// ConflictOverride replaces the existing command with the new one
+	ConflictOverride
+)
+
+// commandOwner returns the registered top-level command named or aliased
+// name, and the module it belongs to
+func (s *Shell) commandOwner(name string) (string, *cobra.Command) {
+	for _, mod := range s.commandModules {
+		for _, cmd := range s.moduleCommands[mod.Name()] {
+			if cmd.Name() == name || cmd.HasAlias(name) {
+				return mod.Name(), cmd
+			}
+		}
+	}
+	return "", nil
+}
+
+// coreCommand returns the core command named name, counting the help
+// command cobra adds to the root
+func (s *Shell) coreCommand(name string) *cobra.Command {
+	for _, cmd := range s.moduleCommands["core"] {
+		if cmd.Name() == name {
+			return cmd
+		}
+	}
+	if name == "help" {
+		for _, cmd := range s.rootCmd.Commands() {
+			if cmd.Name() == "help" {
+				if owner, _ := s.commandOwner("help"); owner == "" {
+					return cmd
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// coreReplacement is a core command replaced by the command of a module
+type coreReplacement struct {
+	core *cobra.Command
+	by   *cobra.Command
+}
+
+// replaceCoreCommand swaps a core command for one registered by a module,
+// remembering it so it can be put back. The swap waits for the module to be
+// enabled if it is registered but disabled.
+func (s *Shell) replaceCoreCommand(moduleName string, existing, cmd *cobra.Command) {
+	s.replacedCore[moduleName] = append(s.replacedCore[moduleName], coreReplacement{core: existing, by: cmd})
+	if _, registered := s.moduleCommands[moduleName]; !registered || s.enabledModules[moduleName] {
+		s.swapCoreCommand(coreReplacement{core: existing, by: cmd}, true)
+	}
+	s.Warn("Command %s of module %s replaces the core one", cmd.Name(), moduleName)
+}
+
+// swapCoreCommand takes a replaced core command out of the shell, or puts
+// it back. A replacement for help also becomes the root's help command, so
+// cobra does not add its own next to it.
+func (s *Shell) swapCoreCommand(r coreReplacement, replaced bool) {
+	switch {
+	case r.core.Name() == "help" && replaced:
+		s.rootCmd.RemoveCommand(r.core)
+		s.rootCmd.SetHelpCommand(r.by)
+	case r.core.Name() == "help":
+		s.rootCmd.SetHelpCommand(r.core)
+		s.rootCmd.AddCommand(r.core)
+	case replaced:
+		s.removeModuleCommand("core", r.core)
+	case !slices.Contains(s.moduleCommands["core"], r.core):
+		s.moduleCommands["core"] = append(s.moduleCommands["core"], r.core)
+		s.rootCmd.AddCommand(r.core)
+		s.addCompleterNodes(r.core)
+	}
+}
+
+// swapCoreCommands takes the core commands a module replaced out of the
+// shell, as it is enabled, or puts them back, as it is disabled
+func (s *Shell) swapCoreCommands(moduleName string, replaced bool) {
+	records := s.replacedCore[moduleName]
+	for i := range records {
+		if replaced {
+			s.swapCoreCommand(records[i], true)
+		} else {
+			s.swapCoreCommand(records[len(records)-1-i], false)
+		}
+	}
+}
+
+// restoreCoreCommands puts back the core commands replaced by cmds of a
+// module, or by any of its commands if cmds is nil, and forgets about them
+func (s *Shell) restoreCoreCommands(moduleName string, cmds []*cobra.Command) {
+	records := s.replacedCore[moduleName]
+	_, registered := s.moduleCommands[moduleName]
+	for i := len(records) - 1; i >= 0; i-- {
+		if cmds != nil && !slices.Contains(cmds, records[i].by) {
+			continue
+		}
+		if !registered || s.enabledModules[moduleName] {
+			s.swapCoreCommand(records[i], false)
+		}
+		records = slices.Delete(records, i, i+1)
+	}
+	if len(records) == 0 {
+		delete(s.replacedCore, moduleName)
+	} else {
+		s.replacedCore[moduleName] = records
+	}
+}
+
+// resolveConflicts applies the conflict policy to the top-level commands a
+// module registers, returning the commands to add. Core commands are
+// defaults any other module may replace with a command of the same name,
+// whatever the policy; a clash with the alias of one is handled by the
+// policy, as any other. Under ConflictReject every other clash is reported
+// and nothing may be added, and the core commands replaced are put back.
+func (s *Shell) resolveConflicts(moduleName string, cmds []*cobra.Command) ([]*cobra.Command, error) {
+	var errs []error
+	for _, cmd := range cmds {
+		if moduleName != "core" {
+			if existing := s.coreCommand(cmd.Name()); existing != nil {
+				s.replaceCoreCommand(moduleName, existing, cmd)
+			}
+		}
+		for _, name := range append([]string{cmd.Name()}, cmd.Aliases...) {
+			owner, existing := s.commandOwner(name)
+			if existing == nil || (owner == "core" && moduleName != "core" && existing.Name() == cmd.Name()) {
+				// A core command it replaces stays until the module is enabled
+				continue
+			}
+			switch s.conflictPolicy {
+			case ConflictPrefix:
+				if name != cmd.Name() {
+					cmd.Aliases = slices.DeleteFunc(cmd.Aliases, func(alias string) bool { return alias == name })
+					continue
+				}
+				prefixed := moduleName + "-" + name
+				if owner, _ := s.commandOwner(prefixed); owner != "" {
+					errs = append(errs, fmt.Errorf("command %s of module %s conflicts with module %s", prefixed, moduleName, owner))
+					continue
+				}
+				cmd.Use = prefixed + strings.TrimPrefix(cmd.Use, name)
+				s.Warn("Command %s of module %s conflicts with module %s, registered as %s", name, moduleName, owner, prefixed)
+			case ConflictOverride:
+				if owner == "core" {
+					s.replaceCoreCommand(moduleName, existing, cmd)
+					continue
+				}
+				s.removeModuleCommand(owner, existing)
+				s.Warn("Command %s of module %s replaces the one from module %s", name, moduleName, owner)
+			default:
+				errs = append(errs, fmt.Errorf("command %s of module %s conflicts with module %s", name, moduleName, owner))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		s.restoreCoreCommands(moduleName, cmds)
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// removeModuleCommand drops a top-level command from a module and, if the
+// module is enabled, from the shell
+func (s *Shell) removeModuleCommand(moduleName string, cmd *cobra.Command) {
+	s.moduleCommands[moduleName] = slices.DeleteFunc(s.moduleCommands[moduleName], func(c *cobra.Command) bool {
+		return c == cmd
+	})
+	if s.isNamespaced(moduleName) && s.namespaces[moduleName] == cmd {
+		delete(s.namespaces, moduleName)
+	}
+	if s.enabledModules[moduleName] {
+		s.rootCmd.RemoveCommand(cmd)
+		s.removeCompleterNodes(cmd)
+	}
+}
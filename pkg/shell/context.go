@@ -0,0 +1,95 @@
+package shell
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+)
+
+// ExecuteCommandContext runs a command like ExecuteCommand, under a context
+// derived from ctx, so the caller can cancel it or give it a deadline. A
+// command executing another passes a context derived from its own
+// cmd.Context(), which lets it through the execution lock it holds.
+func (s *Shell) ExecuteCommandContext(ctx context.Context, command string) error {
+	ctx, unlock := s.lockExecution(ctx)
+	defer unlock()
+	return s.withContext(ctx, func() error { return s.executeLine(command, nil) })
+}
+
+// execution identifies a line being executed, together with the commands
+// it executes in turn
+type execution struct {
+	line string
+}
+
+// executionKey is the context key marking the contexts of an execution
+type executionKey struct{}
+
+// lockExecution takes the lock serializing executions on the command tree,
+// waiting for the one holding it to end, and returns ctx marked as part of
+// the new execution. A ctx already marked by the execution holding the lock
+// belongs to a command it runs, so it goes through. Jobs run under contexts
+// of their own, so the commands they execute wait for the foreground one.
+// The returned function releases the lock.
+func (s *Shell) lockExecution(ctx context.Context) (context.Context, func()) {
+	if holder := s.executing.Load(); holder != nil && ctx.Value(executionKey{}) == holder {
+		return ctx, func() {}
+	}
+	s.execMutex.Lock()
+	e := &execution{}
+	s.executing.Store(e)
+	return context.WithValue(ctx, executionKey{}, e), func() {
+		s.executing.Store(nil)
+		s.execMutex.Unlock()
+	}
+}
+
+// yieldExecution releases the execution lock while a command holding it
+// waits for a job, so the commands the job executes can run. The returned
+// function takes the lock back.
+func (s *Shell) yieldExecution(ctx context.Context) func() {
+	holder := s.executing.Load()
+	if holder == nil || ctx.Value(executionKey{}) != holder {
+		return func() {}
+	}
+	s.executing.Store(nil)
+	s.execMutex.Unlock()
+	return func() {
+		s.execMutex.Lock()
+		s.executing.Store(holder)
+	}
+}
+
+// withContext runs fn with ctx as the context the commands it executes
+// derive theirs from
+func (s *Shell) withContext(ctx context.Context, fn func() error) error {
+	outer := s.execCtx.Swap(&ctx)
+	defer s.execCtx.Store(outer)
+	return fn()
+}
+
+// commandContext returns the context the next command derives its own
+// from: that of the command running it, if any, or the shell's
+func (s *Shell) commandContext() context.Context {
+	if ctx := s.execCtx.Load(); ctx != nil {
+		return *ctx
+	}
+	return s.ctx
+}
+
+// withCommandContext makes ctx the context of cmd, and of the commands it
+// executes in turn. Cobra only hands the root's context to a subcommand
+// that has none, so the subcommand's is set directly. The returned function
+// restores the previous contexts.
+func (s *Shell) withCommandContext(ctx context.Context, cmd *cobra.Command) func() {
+	outer := s.execCtx.Swap(&ctx)
+	if cmd == nil {
+		return func() { s.execCtx.Store(outer) }
+	}
+	previous := cmd.Context()
+	cmd.SetContext(ctx)
+	return func() {
+		cmd.SetContext(previous)
+		s.execCtx.Store(outer)
+	}
+}
@@ -0,0 +1,149 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/chzyer/readline"
+)
+
+// Terminal size used when it cannot be detected
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// Color modes of the color setting
+const (
+	colorAuto int32 = iota
+	colorOn
+	colorOff
+)
+
+// Width returns the width output should be formatted to, by Table, Wrap,
+// Truncate and the pager: the width setting when set, otherwise the
+// terminal width
+func (s *Shell) Width() int {
+	if width := s.widthOverride.Load(); width > 0 {
+		return int(width)
+	}
+	return s.terminalWidth()
+}
+
+// terminalWidth returns the width of the terminal, whatever the width
+// setting, for drawing the prompt, menus and status lines in place
+func (s *Shell) terminalWidth() int {
+	if width := readline.GetScreenWidth(); width > 0 {
+		return width
+	}
+	return defaultWidth
+}
+
+// Height returns the number of rows of the terminal
+func (s *Shell) Height() int {
+	if fd, ok := terminalFd(s.outputStream()); ok {
+		if _, height, err := readline.GetSize(fd); err == nil && height > 0 {
+			return height
+		}
+	}
+	return defaultHeight
+}
+
+// OnResize registers a function called with the new width and height
+// whenever the terminal window is resized
+func (s *Shell) OnResize(fn func(width, height int)) {
+	s.resizeMutex.Lock()
+	defer s.resizeMutex.Unlock()
+	s.resizeHandlers = append(s.resizeHandlers, fn)
+}
+
+// watchResize passes terminal resizes to readline, which redraws the input
+// line, and then to the registered handlers
+func (s *Shell) watchResize(redraw func()) {
+	readline.DefaultOnWidthChanged(func() {
+		redraw()
+		s.resizeStatus()
+		s.resizeMutex.RLock()
+		handlers := append([]func(int, int){}, s.resizeHandlers...)
+		s.resizeMutex.RUnlock()
+		width, height := s.terminalWidth(), s.Height()
+		for _, fn := range handlers {
+			fn(width, height)
+		}
+	})
+}
+
+// ClearScreen clears the terminal and moves the cursor to the top left.
+// While input is being read, the prompt and the line being typed are
+// redrawn at the top.
+func (s *Shell) ClearScreen() {
+	reading := s.rl.Terminal.IsReading()
+	if reading {
+		s.rl.Clean()
+	}
+	out := s.rl.Config.Stdout
+	readline.ClearScreen(out)
+	io.WriteString(out, "\033[J")
+
+	if !reading {
+		io.WriteString(out, string(s.withStatus(nil)))
+		return
+	}
+	context := s.promptContext()
+	s.promptMutex.Lock()
+	s.shownContext = context
+	s.promptMutex.Unlock()
+	if context != "" {
+		io.WriteString(out, context+"\n")
+	}
+	s.rl.Refresh()
+}
+
+// ColorEnabled reports whether output may use colors and other ANSI styles:
+// the color setting when set, otherwise whether stdout is a terminal and
+// NO_COLOR is unset
+func (s *Shell) ColorEnabled() bool {
+	switch s.colorMode.Load() {
+	case colorOn:
+		return true
+	case colorOff:
+		return false
+	}
+	return s.stdoutIsTerminal() && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// registerDisplaySettings exposes the width and color overrides as settings
+func (s *Shell) registerDisplaySettings() {
+	s.RegisterSetting("width", "Output width in columns, or auto to follow the terminal", "auto",
+		func(value string) error {
+			if value == "auto" {
+				s.widthOverride.Store(0)
+				return nil
+			}
+			width, err := strconv.Atoi(value)
+			if err != nil || width <= 0 {
+				return fmt.Errorf("invalid width: %s (expected a number of columns or auto)", value)
+			}
+			s.widthOverride.Store(int32(width))
+			return nil
+		})
+	s.RegisterSetting("color", "Use colors and styles in output (auto, on, off)", "auto",
+		func(value string) error {
+			if value == "auto" {
+				s.colorMode.Store(colorAuto)
+				return nil
+			}
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return fmt.Errorf("invalid color mode: %s (expected auto, on or off)", value)
+			}
+			if enabled {
+				s.colorMode.Store(colorOn)
+			} else {
+				s.colorMode.Store(colorOff)
+			}
+			return nil
+		})
+}
@@ -0,0 +1,77 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// Exit statuses the shell reports for failures of its own, following the
+// conventions of POSIX shells and timeout(1)
+const (
+	statusTimeout        = 124
+	statusUnknownCommand = 127
+	statusInterrupted    = 130
+)
+
+// LastStatus returns the exit status of the last command line executed:
+// 0 on success, 1 for a plain error, or the status the command chose
+func (s *Shell) LastStatus() int {
+	return int(s.lastStatus.Load())
+}
+
+// setLastStatus records the status a command line ended with, and
+// publishes it under shellapi.StateLastStatus when it changed, so its
+// watchers hear of changes only
+func (s *Shell) setLastStatus(status int) {
+	if int(s.lastStatus.Swap(int32(status))) == status {
+		return
+	}
+	s.stateMutex.Lock()
+	s.setState(shellapi.StateLastStatus, status)
+}
+
+// recordStatus records the status err stands for as the last status, and
+// returns err as an ExitError
+func (s *Shell) recordStatus(err error) error {
+	var exitErr *shellapi.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		err = &shellapi.ExitError{Code: 1, Err: err}
+	}
+	s.setLastStatus(shellapi.ExitStatus(err))
+	return err
+}
+
+// withExitStatus wraps the error cmd returned in an ExitError carrying its
+// status, unless it already is one. A nil cmd is a line naming no command.
+func withExitStatus(ctx context.Context, cmd *cobra.Command, err error) error {
+	var exitErr *shellapi.ExitError
+	if err == nil || errors.As(err, &exitErr) {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.Canceled) && errors.Is(context.Cause(ctx), ErrInterrupted):
+		return &shellapi.ExitError{Code: statusInterrupted, Err: ErrInterrupted}
+	case errors.Is(err, ErrCommandTimeout):
+		return &shellapi.ExitError{Code: statusTimeout, Err: err}
+	case cmd == nil:
+		return &shellapi.ExitError{Code: statusUnknownCommand, Err: err}
+	}
+	return &shellapi.ExitError{Code: annotatedStatus(cmd), Err: err}
+}
+
+// annotatedStatus returns the failure status set with
+// shellapi.AnnotationExitStatus on cmd or its nearest parent, or 1
+func annotatedStatus(cmd *cobra.Command) int {
+	for c := cmd; c != nil; c = c.Parent() {
+		if value, ok := c.Annotations[shellapi.AnnotationExitStatus]; ok {
+			if status, err := strconv.Atoi(value); err == nil && status != 0 {
+				return status
+			}
+		}
+	}
+	return 1
+}
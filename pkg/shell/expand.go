@@ -0,0 +1,86 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expandHistory replaces history references in a typed line: !! anywhere
+// outside quotes with the previous command, and !prefix at the start of the
+// line with the most recent command starting with prefix. It reports
+// whether anything was expanded.
+func (s *Shell) expandHistory(line string) (string, bool, error) {
+	if !s.historyExpansion.Load() || !strings.Contains(line, "!") {
+		return line, false, nil
+	}
+	history := s.History()
+	expanded := false
+
+	previous := ""
+	if len(history) > 0 {
+		previous = history[len(history)-1]
+	}
+	if replaced, ok := replaceUnquoted(line, "!!", previous); ok {
+		if len(history) == 0 {
+			return "", false, fmt.Errorf("!!: event not found")
+		}
+		line = replaced
+		expanded = true
+	}
+
+	if word, rest, _ := strings.Cut(line, " "); !expanded && len(word) > 1 && word[0] == '!' {
+		prefix := word[1:]
+		for i := len(history) - 1; i >= 0; i-- {
+			if strings.HasPrefix(history[i], prefix) {
+				line = strings.TrimSpace(history[i] + " " + rest)
+				expanded = true
+				break
+			}
+		}
+		if !expanded {
+			return "", false, fmt.Errorf("%s: event not found", word)
+		}
+	}
+	return line, expanded, nil
+}
+
+// expandStatus replaces $? in a typed line with the exit status of the
+// previous command line. A $? in quotes is left as it is.
+func (s *Shell) expandStatus(line string) string {
+	if !strings.Contains(line, "$?") {
+		return line
+	}
+	line, _ = replaceUnquoted(line, "$?", strconv.Itoa(s.LastStatus()))
+	return line
+}
+
+// replaceUnquoted replaces each token in line outside single or double
+// quotes with value, reporting whether any was replaced
+func replaceUnquoted(line, token, value string) (string, bool) {
+	var b strings.Builder
+	var quote byte
+	replaced := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\' && i+1 < len(line):
+			b.WriteByte(c)
+			i++
+			c = line[i]
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(line[i:], token):
+			b.WriteString(value)
+			i += len(token) - 1
+			replaced = true
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), replaced
+}
@@ -0,0 +1,112 @@
+package shell
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// externalManifest describes a module whose commands run external programs.
+// It is read from a JSON file by LoadModulesFromDir:
+//
+//	{
+//	  "name": "deploy",
+//	  "description": "Deployment scripts",
+//	  "commands": [
+//	    {"name": "push", "short": "Push a release", "exec": ["./push.sh"]}
+//	  ]
+//	}
+type externalManifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+	Commands    []struct {
+		Name  string   `json:"name"`
+		Short string   `json:"short"`
+		Exec  []string `json:"exec"`
+	} `json:"commands"`
+}
+
+// externalModule is a module described by a manifest. Relative programs
+// with a path separator are resolved against the manifest's directory.
+type externalModule struct {
+	shell    shellapi.ShellAPI
+	manifest externalManifest
+	dir      string
+}
+
+// loadManifest reads a module manifest
+func loadManifest(path string) (*externalModule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest externalManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Name == "" {
+		return nil, fmt.Errorf("manifest has no module name")
+	}
+	for _, command := range manifest.Commands {
+		if command.Name == "" || len(command.Exec) == 0 {
+			return nil, fmt.Errorf("every command needs a name and a program to exec")
+		}
+	}
+	return &externalModule{manifest: manifest, dir: filepath.Dir(path)}, nil
+}
+
+// Name returns the module name from the manifest
+func (m *externalModule) Name() string {
+	return m.manifest.Name
+}
+
+// Metadata describes the module with the manifest's fields
+func (m *externalModule) Metadata() shellapi.ModuleMetadata {
+	return shellapi.ModuleMetadata{
+		Version:     m.manifest.Version,
+		Description: m.manifest.Description,
+		Author:      m.manifest.Author,
+	}
+}
+
+// Capabilities declares that the module's commands run programs
+func (m *externalModule) Capabilities() []shellapi.Capability {
+	return []shellapi.Capability{shellapi.CapabilityExec}
+}
+
+// Initialize stores the shell reference
+func (m *externalModule) Initialize(s shellapi.ShellAPI) {
+	m.shell = s
+}
+
+// GetCommands returns a command per manifest entry, passing its arguments
+// on to the program
+func (m *externalModule) GetCommands() []*cobra.Command {
+	commands := make([]*cobra.Command, 0, len(m.manifest.Commands))
+	for _, command := range m.manifest.Commands {
+		program := command.Exec[0]
+		if !filepath.IsAbs(program) && strings.ContainsRune(program, filepath.Separator) {
+			program = filepath.Join(m.dir, program)
+		}
+		baseArgs := command.Exec[1:]
+		commands = append(commands, &cobra.Command{
+			Use:                command.Name,
+			Short:              command.Short,
+			DisableFlagParsing: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				c := exec.CommandContext(cmd.Context(), program, append(append([]string{}, baseArgs...), args...)...)
+				c.Stdin, c.Stdout, c.Stderr = m.shell.Stdin(), m.shell.Stdout(), m.shell.Stderr()
+				return c.Run()
+			},
+		})
+	}
+	return commands
+}
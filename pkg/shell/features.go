@@ -0,0 +1,92 @@
+package shell
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// feature is a named flag modules check before exposing experimental
+// behavior
+type feature struct {
+	description string
+	enabled     bool
+}
+
+// featuresEnv returns the environment variable listing the features
+// enabled for an application, such as MYSHELL_FEATURES
+func featuresEnv(app string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, app)
+	return name + "_FEATURES"
+}
+
+// enableFeaturesFromEnv turns on the comma separated features in the
+// application's features environment variable
+func (s *Shell) enableFeaturesFromEnv(app string) {
+	for _, name := range strings.Split(os.Getenv(featuresEnv(app)), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			s.SetFeature(name, true)
+		}
+	}
+}
+
+// RegisterFeature declares a feature flag so it is listed by the feature
+// command while still off. Registering does not change whether it is on.
+func (s *Shell) RegisterFeature(name, description string) {
+	s.featuresMutex.Lock()
+	defer s.featuresMutex.Unlock()
+	if f, ok := s.features[name]; ok {
+		f.description = description
+		return
+	}
+	s.features[name] = &feature{description: description}
+}
+
+// FeatureEnabled reports whether a feature flag is on. Unknown features
+// are off.
+func (s *Shell) FeatureEnabled(name string) bool {
+	s.featuresMutex.RLock()
+	defer s.featuresMutex.RUnlock()
+	f, ok := s.features[name]
+	return ok && f.enabled
+}
+
+// SetFeature turns a feature flag on or off. Modules read flags when they
+// are registered, so changes apply to modules registered afterwards and to
+// commands that check the flag when they run.
+func (s *Shell) SetFeature(name string, enabled bool) {
+	s.featuresMutex.Lock()
+	defer s.featuresMutex.Unlock()
+	if f, ok := s.features[name]; ok {
+		f.enabled = enabled
+		return
+	}
+	s.features[name] = &feature{enabled: enabled}
+}
+
+// Features returns every known feature flag, sorted by name
+func (s *Shell) Features() []shellapi.Feature {
+	s.featuresMutex.RLock()
+	defer s.featuresMutex.RUnlock()
+
+	features := make([]shellapi.Feature, 0, len(s.features))
+	for name, f := range s.features {
+		features = append(features, shellapi.Feature{
+			Name:        name,
+			Description: f.description,
+			Enabled:     f.enabled,
+		})
+	}
+	sort.Slice(features, func(i, j int) bool {
+		return features[i].Name < features[j].Name
+	})
+	return features
+}
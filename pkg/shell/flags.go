@@ -0,0 +1,149 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// FlagError is returned when a command's flags cannot be parsed. Flags holds
+// the flags the error is about, or close matches for an unknown flag.
+type FlagError struct {
+	Command *cobra.Command
+	Flags   []*pflag.Flag
+	Err     error
+}
+
+func (e *FlagError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FlagError) Unwrap() error {
+	return e.Err
+}
+
+// Usage returns the usage lines of the flags the error is about
+func (e *FlagError) Usage() string {
+	set := pflag.NewFlagSet(e.Command.Name(), pflag.ContinueOnError)
+	for _, f := range e.Flags {
+		set.AddFlag(f)
+	}
+	return set.FlagUsages()
+}
+
+var (
+	// flagNamePattern finds long flag names in pflag error messages
+	flagNamePattern = regexp.MustCompile(`--([A-Za-z0-9][\w-]*)`)
+	// shorthandPattern finds shorthands in messages like `'c' in -c`
+	shorthandPattern = regexp.MustCompile(`'(.)' in -`)
+)
+
+// flagError builds a FlagError from the error pflag reported for cmd
+func flagError(cmd *cobra.Command, err error) error {
+	flags := cmd.Flags()
+	msg := err.Error()
+	found := []*pflag.Flag{}
+	seen := make(map[*pflag.Flag]bool)
+	add := func(f *pflag.Flag) {
+		if f != nil && !f.Hidden && !seen[f] {
+			seen[f] = true
+			found = append(found, f)
+		}
+	}
+
+	for _, m := range flagNamePattern.FindAllStringSubmatch(msg, -1) {
+		if f := flags.Lookup(m[1]); f != nil {
+			add(f)
+			continue
+		}
+		// Unknown flag, offer the closest ones instead
+		flags.VisitAll(func(f *pflag.Flag) {
+			if _, ok := fuzzyMatch(m[1], f.Name); ok {
+				add(f)
+			}
+		})
+	}
+	if m := shorthandPattern.FindStringSubmatch(msg); m != nil {
+		add(flags.ShorthandLookup(m[1]))
+	}
+	return &FlagError{Command: cmd, Flags: found, Err: err}
+}
+
+// printError reports a failed command line; flag errors get the relevant
+// usage lines and a pointer to the full help instead of the whole usage.
+// The report is written to w: stdout at the prompt, stderr otherwise.
+func (s *Shell) printError(w io.Writer, err error) {
+	fmt.Fprintf(w, "Error: %v\n", err)
+	var flagErr *FlagError
+	if !errors.As(err, &flagErr) {
+		return
+	}
+	if usage := flagErr.Usage(); usage != "" {
+		fmt.Fprint(w, usage)
+	}
+	fmt.Fprintf(w, "Run 'help %s' for usage.\n", strings.TrimSpace(s.commandPath(flagErr.Command)))
+}
+
+// flagDefault holds the value a flag had when its command was registered
+type flagDefault struct {
+	value string
+	slice []string
+}
+
+// snapshotFlags records the current value of every flag in a command tree
+func (s *Shell) snapshotFlags(cmd *cobra.Command) {
+	visitFlags(cmd, func(f *pflag.Flag) {
+		if _, ok := s.flagDefaults[f]; ok {
+			return
+		}
+		def := flagDefault{value: f.Value.String()}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			def.slice = append([]string{}, sv.GetSlice()...)
+		}
+		s.flagDefaults[f] = def
+	})
+}
+
+// resetFlags restores every flag in the command tree to its registered value
+// and clears the Changed markers, so values don't leak between executions.
+// Flags of commands running as jobs are left alone until the jobs end.
+func (s *Shell) resetFlags() {
+	inUse := make(map[*pflag.Flag]bool)
+	for cmd := range s.jobCommands() {
+		cmd.Flags().VisitAll(func(f *pflag.Flag) { inUse[f] = true })
+	}
+	for _, cmd := range s.running {
+		cmd.Flags().VisitAll(func(f *pflag.Flag) { inUse[f] = true })
+	}
+	visitFlags(s.rootCmd, func(f *pflag.Flag) {
+		if inUse[f] {
+			return
+		}
+		def, ok := s.flagDefaults[f]
+		if !ok {
+			// Flags cobra adds lazily (like --help) were never snapshotted
+			def = flagDefault{value: f.DefValue}
+		}
+
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(append([]string{}, def.slice...))
+		} else if f.Value.String() != def.value {
+			f.Value.Set(def.value)
+		}
+		f.Changed = false
+	})
+}
+
+// visitFlags calls fn for every local and persistent flag in a command tree
+func visitFlags(cmd *cobra.Command, fn func(*pflag.Flag)) {
+	cmd.Flags().VisitAll(fn)
+	cmd.PersistentFlags().VisitAll(fn)
+	for _, child := range cmd.Commands() {
+		visitFlags(child, fn)
+	}
+}
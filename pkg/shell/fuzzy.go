@@ -0,0 +1,70 @@
+package shell
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fuzzyMatch reports whether all characters of pattern appear in candidate
+// in order, ignoring case. Higher scores mean a better match: consecutive
+// characters and matches at the start of the candidate or of a word score
+// more than scattered ones.
+func fuzzyMatch(pattern, candidate string) (int, bool) {
+	pattern = strings.ToLower(pattern)
+	candidate = strings.ToLower(candidate)
+	if pattern == "" {
+		return 0, true
+	}
+
+	score := 0
+	prev := -2
+	p := 0
+	for i, r := range candidate {
+		pr, size := utf8.DecodeRuneInString(pattern[p:])
+		if r != pr {
+			continue
+		}
+		switch {
+		case i == 0:
+			score += 3
+		case i == prev+1:
+			score += 2
+		case isWordBoundary(candidate[i-1]):
+			score += 2
+		default:
+			score++
+		}
+		prev = i
+		p += size
+		if p == len(pattern) {
+			return score, true
+		}
+	}
+	return 0, false
+}
+
+// isWordBoundary reports whether b separates words in a command name
+func isWordBoundary(b byte) bool {
+	return b == ' ' || b == '-' || b == '_'
+}
+
+// fuzzyHighlight renders candidate with the characters matched by pattern
+// in bold, using the same matching rules as fuzzyMatch
+func fuzzyHighlight(pattern, candidate string) string {
+	lower := strings.ToLower(pattern)
+	var b strings.Builder
+	p := 0
+	for _, r := range candidate {
+		if p < len(lower) {
+			pr, size := utf8.DecodeRuneInString(lower[p:])
+			if unicode.ToLower(r) == pr {
+				b.WriteString("\033[1m" + string(r) + "\033[0m")
+				p += size
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
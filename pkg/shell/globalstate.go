@@ -0,0 +1,56 @@
+package shell
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// globalState is shared by every shell in the process, while each shell's
+// own state belongs to its session
+var globalState = struct {
+	values map[string]interface{}
+	mu     sync.RWMutex
+}{values: make(map[string]interface{})}
+
+// SetGlobalState sets a value in the process-wide state shared by every
+// shell, for data such as connection pools that outlives one session. The
+// other state methods are scoped to this shell's session.
+func (s *Shell) SetGlobalState(key string, value interface{}) {
+	globalState.mu.Lock()
+	defer globalState.mu.Unlock()
+	globalState.values[key] = value
+}
+
+// GetGlobalState gets a value from the process-wide state
+func (s *Shell) GetGlobalState(key string) (interface{}, bool) {
+	globalState.mu.RLock()
+	defer globalState.mu.RUnlock()
+	value, ok := globalState.values[key]
+	return value, ok
+}
+
+// DeleteGlobalState removes a key from the process-wide state, reporting
+// whether it was there
+func (s *Shell) DeleteGlobalState(key string) bool {
+	globalState.mu.Lock()
+	defer globalState.mu.Unlock()
+	_, ok := globalState.values[key]
+	delete(globalState.values, key)
+	return ok
+}
+
+// GlobalStateKeys returns the sorted process-wide state keys starting with
+// prefix
+func (s *Shell) GlobalStateKeys(prefix string) []string {
+	globalState.mu.RLock()
+	defer globalState.mu.RUnlock()
+	keys := []string{}
+	for key := range globalState.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package shell
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/module"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// healthCheck is a named diagnostic registered by a module
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// RegisterHealthCheck adds a diagnostic run by the doctor command
+func (s *Shell) RegisterHealthCheck(name string, check func() error) {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+	s.healthChecks = append(s.healthChecks, healthCheck{name: name, check: check})
+}
+
+// RunHealthChecks runs every registered check in registration order
+func (s *Shell) RunHealthChecks() []shellapi.HealthResult {
+	s.healthMutex.RLock()
+	checks := append([]healthCheck{}, s.healthChecks...)
+	s.healthMutex.RUnlock()
+
+	results := make([]shellapi.HealthResult, 0, len(checks))
+	for _, hc := range checks {
+		results = append(results, shellapi.HealthResult{Name: hc.name, Err: hc.check()})
+	}
+	return results
+}
+
+// moduleHealthTimeout bounds how long a module's Health method may take
+const moduleHealthTimeout = 5 * time.Second
+
+// ModuleHealth calls the Health method of every enabled module having one,
+// in registration order. The checks run together; one panicking or taking
+// longer than moduleHealthTimeout is reported as failing.
+func (s *Shell) ModuleHealth() []shellapi.ModuleHealth {
+	results := make([]shellapi.ModuleHealth, len(s.commandModules))
+	var wg sync.WaitGroup
+	for i, mod := range s.commandModules {
+		results[i] = shellapi.ModuleHealth{Name: mod.Name(), Enabled: s.IsModuleEnabled(mod.Name())}
+		checker, ok := mod.(module.HealthChecker)
+		if !ok || !results[i].Enabled {
+			continue
+		}
+		results[i].Checked = true
+		wg.Add(1)
+		go func(result *shellapi.ModuleHealth) {
+			defer wg.Done()
+			result.Err = checkModuleHealth(result.Name, checker)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// checkModuleHealth calls the Health method of a module, giving up once
+// moduleHealthTimeout has passed. A hung check is left to finish on its own.
+func checkModuleHealth(moduleName string, checker module.HealthChecker) error {
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if panicErr := callModule(moduleName, "Health", func() { err = checker.Health() }); panicErr != nil {
+			err = panicErr
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(moduleHealthTimeout):
+		return fmt.Errorf("health check timed out after %s", moduleHealthTimeout)
+	}
+}
@@ -0,0 +1,390 @@
+package shell
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// encryptedHistoryHeader starts the first line of an encrypted history file,
+// followed by the base64 encoded key derivation salt
+const encryptedHistoryHeader = "gocmd2-history-v1"
+
+// pbkdf2Iterations is the work factor used to derive the history key
+const pbkdf2Iterations = 600000
+
+// KeySource returns the secret used to encrypt the history file
+type KeySource func() ([]byte, error)
+
+// Passphrase returns a KeySource that always yields the given passphrase
+func Passphrase(passphrase string) KeySource {
+	return func() ([]byte, error) {
+		return []byte(passphrase), nil
+	}
+}
+
+// defaultHistoryLimit is the number of entries kept unless WithHistoryLimit
+// says otherwise
+const defaultHistoryLimit = 500
+
+// dataFile returns the path of a per-application file under the XDG data
+// directory, or "" to keep the data in memory when there is no home
+// directory to put it in
+func dataFile(app, name string) string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	dir = filepath.Join(dir, app)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+// prepareHistoryFile creates a missing plaintext history file readable only
+// by the user, before readline opens it with looser permissions
+func prepareHistoryFile(path string) {
+	if path == "" {
+		return
+	}
+	if f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600); err == nil {
+		f.Close()
+	}
+}
+
+// saveHistory records an entered line unless an ignore rule applies. raw
+// is the line as typed, before surrounding space was trimmed.
+func (s *Shell) saveHistory(raw, line string) {
+	if s.historyDisabled {
+		return
+	}
+	if s.historyIgnoreSpace.Load() && strings.HasPrefix(raw, " ") {
+		return
+	}
+	if s.excludedFromHistory(line) {
+		return
+	}
+
+	// Secrets never reach the history file
+	entry := s.Redact(line)
+	if s.historyIgnoreDups.Load() {
+		s.historyMutex.RLock()
+		n := len(s.historyRecords)
+		duplicate := n > 0 && s.historyRecords[n-1].Line == entry
+		s.historyMutex.RUnlock()
+		if duplicate {
+			return
+		}
+	}
+
+	// The record is written once the command finishes, see finishHistory
+	s.rl.SaveHistory(entry)
+	s.recordHistory(shellapi.HistoryRecord{Line: entry, Time: time.Now()})
+	s.historyMutex.Lock()
+	s.historyPending = true
+	s.historyMutex.Unlock()
+}
+
+// RegisterHistoryExclusion adds a pattern; lines matching it are never
+// written to the history
+func (s *Shell) RegisterHistoryExclusion(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyExclusions = append(s.historyExclusions, re)
+	return nil
+}
+
+// excludedFromHistory reports whether line matches an exclusion pattern or
+// runs a command annotated with shellapi.AnnotationNoHistory
+func (s *Shell) excludedFromHistory(line string) bool {
+	s.historyMutex.RLock()
+	for _, re := range s.historyExclusions {
+		if re.MatchString(line) {
+			s.historyMutex.RUnlock()
+			return true
+		}
+	}
+	s.historyMutex.RUnlock()
+
+	args, err := s.parseLine(line)
+	if err != nil {
+		return false
+	}
+	cmd, _, err := s.rootCmd.Find(args)
+	if err != nil {
+		return false
+	}
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if _, ok := cmd.Annotations[shellapi.AnnotationNoHistory]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recordHistory adds a record to the shell's own copy of the history
+func (s *Shell) recordHistory(record shellapi.HistoryRecord) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyRecords = append(s.historyRecords, historyEntry{HistoryRecord: record})
+	if len(s.historyRecords) > s.historyLimit {
+		s.historyRecords = s.historyRecords[len(s.historyRecords)-s.historyLimit:]
+	}
+}
+
+// History returns the commands entered so far, oldest first
+func (s *Shell) History() []string {
+	s.historyMutex.RLock()
+	defer s.historyMutex.RUnlock()
+	lines := make([]string, len(s.historyRecords))
+	for i, record := range s.historyRecords {
+		lines[i] = record.Line
+	}
+	return lines
+}
+
+// ClearHistory forgets every entry, in memory and in the history file
+func (s *Shell) ClearHistory() error {
+	s.historyMutex.Lock()
+	s.historyRecords = nil
+	s.historyPending = false
+	s.historyMutex.Unlock()
+	s.rl.ResetHistory()
+
+	if s.historyPath == "" {
+		return nil
+	}
+	if s.historyKey != nil {
+		// Start a fresh file with a new salt
+		secret, err := s.historyKey()
+		if err != nil {
+			return fmt.Errorf("history key: %w", err)
+		}
+		return s.createEncryptedHistory(secret)
+	}
+	if err := os.Truncate(s.historyPath, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.historyFileEntries = 0
+	return nil
+}
+
+// loadHistory reads the unencrypted history file into the shell's copy of
+// the history. In text format readline loads the same file for its own
+// navigation, otherwise the entries are handed to readline here.
+func (s *Shell) loadHistory() {
+	data, err := os.ReadFile(s.historyPath)
+	if err != nil {
+		return
+	}
+	s.historyMutex.Lock()
+	s.historyRecords = nil
+	s.historyMutex.Unlock()
+	s.historyFileEntries = 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		record := decodeHistoryRecord(line)
+		if s.shellWritesHistory() {
+			s.rl.SaveHistory(record.Line)
+		}
+		s.recordHistory(record)
+		s.historyFileEntries++
+	}
+}
+
+// trimHistoryFile rewrites the history file with only the newest entries
+// once it has grown a tenth past the history limit, so long-lived shells
+// do not grow it without bound. The file is rewritten in place because
+// readline keeps it open for appending.
+func (s *Shell) trimHistoryFile() error {
+	if s.historyPath == "" || s.historyFileEntries <= s.historyLimit+s.historyLimit/10 {
+		return nil
+	}
+
+	var b strings.Builder
+	if s.historyCipher != nil {
+		b.WriteString(s.historyCipher.header())
+	}
+	s.historyMutex.RLock()
+	records := []shellapi.HistoryRecord{}
+	for _, entry := range s.historyRecords {
+		if !entry.unsaved {
+			records = append(records, entry.HistoryRecord)
+		}
+	}
+	s.historyMutex.RUnlock()
+	for _, record := range records {
+		line, err := s.encodeHistoryRecord(record)
+		if err != nil {
+			return err
+		}
+		if s.historyCipher != nil {
+			if line, err = s.historyCipher.seal(line); err != nil {
+				return err
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	if err := os.WriteFile(s.historyPath, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	s.historyFileEntries = len(records)
+	return nil
+}
+
+// historyCipher seals and opens individual history entries
+type historyCipher struct {
+	aead cipher.AEAD
+	salt []byte
+}
+
+// header returns the first line of a file encrypted with this cipher
+func (c *historyCipher) header() string {
+	return encryptedHistoryHeader + " " + base64.StdEncoding.EncodeToString(c.salt) + "\n"
+}
+
+// newHistoryCipher derives an AES-256-GCM key from secret and salt, or uses
+// secret as the key itself when raw is set
+func newHistoryCipher(secret, salt []byte, raw bool) (*historyCipher, error) {
+	key := secret
+	if raw {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("history key must be 32 bytes, got %d", len(key))
+		}
+	} else {
+		var err error
+		key, err = pbkdf2.Key(sha256.New, string(secret), salt, pbkdf2Iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &historyCipher{aead: aead, salt: salt}, nil
+}
+
+// seal encrypts a single entry into a base64 encoded record
+func (c *historyCipher) seal(entry string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(entry), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a record produced by seal
+func (c *historyCipher) open(record string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(record)
+	if err != nil {
+		return "", err
+	}
+	size := c.aead.NonceSize()
+	if len(data) < size {
+		return "", errors.New("record too short")
+	}
+	plain, err := c.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// loadEncryptedHistory reads the encrypted history file into readline's
+// in-memory history and the shell's copy, creating the file with a fresh
+// salt if needed
+func (s *Shell) loadEncryptedHistory() error {
+	if s.historyPath == "" {
+		return nil
+	}
+	secret, err := s.historyKey()
+	if err != nil {
+		return fmt.Errorf("history key: %w", err)
+	}
+
+	f, err := os.Open(s.historyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.createEncryptedHistory(secret)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return s.createEncryptedHistory(secret)
+	}
+	header, encodedSalt, _ := strings.Cut(scanner.Text(), " ")
+	if header != encryptedHistoryHeader {
+		return fmt.Errorf("%s is not an encrypted history file", s.historyPath)
+	}
+	salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return fmt.Errorf("invalid history salt: %w", err)
+	}
+	s.historyCipher, err = newHistoryCipher(secret, salt, s.historyKeyRaw)
+	if err != nil {
+		return err
+	}
+
+	s.historyMutex.Lock()
+	s.historyRecords = nil
+	s.historyMutex.Unlock()
+	for scanner.Scan() {
+		line, err := s.historyCipher.open(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("cannot decrypt history (wrong key?): %w", err)
+		}
+		record := decodeHistoryRecord(line)
+		s.rl.SaveHistory(record.Line)
+		s.recordHistory(record)
+		s.historyFileEntries++
+	}
+	return scanner.Err()
+}
+
+// createEncryptedHistory writes a new, empty encrypted history file
+func (s *Shell) createEncryptedHistory(secret []byte) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	var err error
+	s.historyCipher, err = newHistoryCipher(secret, salt, s.historyKeyRaw)
+	if err != nil {
+		return err
+	}
+	s.historyFileEntries = 0
+	return os.WriteFile(s.historyPath, []byte(s.historyCipher.header()), 0600)
+}
@@ -0,0 +1,166 @@
+package shell
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// HistoryFormat selects how entries are written to the history file
+type HistoryFormat int
+
+const (
+	// HistoryRecords writes one JSON record per entry, keeping when each
+	// command ran, how long it took and whether it failed
+	HistoryRecords HistoryFormat = iota
+	// HistoryText writes bare command lines, the format readline reads and
+	// writes itself, for files shared with other readline programs
+	HistoryText
+)
+
+// historyEntry is a record in the shell's copy of the history
+type historyEntry struct {
+	shellapi.HistoryRecord
+
+	// unsaved marks entries made in in-memory mode, never written to disk
+	unsaved bool
+}
+
+// historyLine is the on-disk form of a history record
+type historyLine struct {
+	Line       string    `json:"line"`
+	Time       time.Time `json:"time"`
+	Duration   int64     `json:"duration_ns"`
+	ExitStatus int       `json:"exit_status"`
+}
+
+// encodeHistoryRecord renders a record as a line of the history file
+func (s *Shell) encodeHistoryRecord(record shellapi.HistoryRecord) (string, error) {
+	if s.historyFormat == HistoryText {
+		return record.Line, nil
+	}
+	data, err := json.Marshal(historyLine{
+		Line:       record.Line,
+		Time:       record.Time,
+		Duration:   record.Duration.Nanoseconds(),
+		ExitStatus: record.ExitStatus,
+	})
+	return string(data), err
+}
+
+// decodeHistoryRecord reads a line of the history file. Lines that are not
+// JSON records are bare commands, from text format files.
+func decodeHistoryRecord(text string) shellapi.HistoryRecord {
+	if strings.HasPrefix(text, "{") {
+		var line historyLine
+		if err := json.Unmarshal([]byte(text), &line); err == nil && line.Line != "" {
+			return shellapi.HistoryRecord{
+				Line:       line.Line,
+				Time:       line.Time,
+				Duration:   time.Duration(line.Duration),
+				ExitStatus: line.ExitStatus,
+			}
+		}
+	}
+	return shellapi.HistoryRecord{Line: text}
+}
+
+// shellWritesHistory reports whether the shell, rather than readline, keeps
+// the history file: always except for unencrypted text format that has not
+// been detached from readline
+func (s *Shell) shellWritesHistory() bool {
+	return s.historyKey != nil || s.historyFormat != HistoryText || s.historyDetached
+}
+
+// SetHistoryInMemory switches in-memory history on or off. While on, new
+// commands are recalled with the arrows, search and history as usual but
+// never written to disk, not even when the file is later rewritten.
+func (s *Shell) SetHistoryInMemory(inMemory bool) {
+	if inMemory && !s.shellWritesHistory() {
+		// Take the file away from readline, keeping its entries for recall
+		s.historyDetached = true
+		s.rl.SetHistoryPath("")
+		for _, line := range s.History() {
+			s.rl.SaveHistory(line)
+		}
+	}
+	s.historyInMemory.Store(inMemory)
+}
+
+// HistoryInMemory reports whether new history entries stay off disk
+func (s *Shell) HistoryInMemory() bool {
+	return s.historyInMemory.Load()
+}
+
+// finishHistory completes the record of the command just run with its
+// duration and exit status, then writes it to the history file
+func (s *Shell) finishHistory(err error) {
+	s.historyMutex.Lock()
+	if !s.historyPending || len(s.historyRecords) == 0 {
+		s.historyMutex.Unlock()
+		return
+	}
+	s.historyPending = false
+	record := &s.historyRecords[len(s.historyRecords)-1]
+	record.Duration = time.Since(record.Time)
+	record.ExitStatus = shellapi.ExitStatus(err)
+	record.unsaved = s.historyInMemory.Load()
+	finished := *record
+	s.historyMutex.Unlock()
+
+	if finished.unsaved {
+		return
+	}
+
+	if err := s.appendHistoryRecord(finished.HistoryRecord); err != nil {
+		fmt.Fprintf(s.Stdout(), "Error saving history: %v\n", err)
+	}
+	if err := s.trimHistoryFile(); err != nil {
+		fmt.Fprintf(s.Stdout(), "Error trimming history: %v\n", err)
+	}
+}
+
+// appendHistoryRecord adds a record to the history file
+func (s *Shell) appendHistoryRecord(record shellapi.HistoryRecord) error {
+	if s.historyPath == "" {
+		return nil
+	}
+	s.historyFileEntries++
+	if !s.shellWritesHistory() {
+		// readline appended the line when it was saved
+		return nil
+	}
+	line, err := s.encodeHistoryRecord(record)
+	if err != nil {
+		return err
+	}
+	if s.historyCipher != nil {
+		if line, err = s.historyCipher.seal(line); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(s.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+// HistoryRecords returns the commands entered so far with when they ran, how
+// long they took and their exit status, oldest first. Entries loaded from
+// text format files only have the line.
+func (s *Shell) HistoryRecords() []shellapi.HistoryRecord {
+	s.historyMutex.RLock()
+	defer s.historyMutex.RUnlock()
+	records := make([]shellapi.HistoryRecord, len(s.historyRecords))
+	for i, entry := range s.historyRecords {
+		records[i] = entry.HistoryRecord
+	}
+	return records
+}
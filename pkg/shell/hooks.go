@@ -0,0 +1,130 @@
+package shell
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// loopHooks are the functions run at each stage of the REPL loop, and the
+// middleware wrapping each command
+type loopHooks struct {
+	beforeReadline []func()
+	afterReadline  []func(line string) string
+	beforeExecute  []func(line string) error
+	afterExecute   []func(line string, err error)
+	middleware     []shellapi.Middleware
+}
+
+// BeforeReadline registers a function run before each prompt is shown
+func (s *Shell) BeforeReadline(fn func()) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.hooks.beforeReadline = append(s.hooks.beforeReadline, fn)
+}
+
+// AfterReadline registers a function that receives each line read and
+// returns the line to use instead. Returning an empty line skips it.
+func (s *Shell) AfterReadline(fn func(line string) string) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.hooks.afterReadline = append(s.hooks.afterReadline, fn)
+}
+
+// BeforeExecute registers a function run before each command line executes.
+// Returning an error prevents the command from running and reports the error.
+func (s *Shell) BeforeExecute(fn func(line string) error) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.hooks.beforeExecute = append(s.hooks.beforeExecute, fn)
+}
+
+// AfterExecute registers a function run after each command line executes,
+// with the error it returned
+func (s *Shell) AfterExecute(fn func(line string, err error)) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.hooks.afterExecute = append(s.hooks.afterExecute, fn)
+}
+
+// Use adds middleware wrapping the execution of every command, whether
+// typed at the prompt or run with ExecuteCommand. The first middleware
+// registered is the outermost, so it sees a command before the others
+// and its error after them.
+func (s *Shell) Use(middleware ...shellapi.Middleware) {
+	s.hooksMutex.Lock()
+	defer s.hooksMutex.Unlock()
+	s.hooks.middleware = append(s.hooks.middleware, middleware...)
+}
+
+// loopHooks returns a copy of the registered hooks so they can run unlocked
+func (s *Shell) loopHooks() loopHooks {
+	s.hooksMutex.RLock()
+	defer s.hooksMutex.RUnlock()
+	return loopHooks{
+		beforeReadline: append([]func(){}, s.hooks.beforeReadline...),
+		afterReadline:  append([]func(string) string{}, s.hooks.afterReadline...),
+		beforeExecute:  append([]func(string) error{}, s.hooks.beforeExecute...),
+		afterExecute:   append([]func(string, error){}, s.hooks.afterExecute...),
+		middleware:     append([]shellapi.Middleware{}, s.hooks.middleware...),
+	}
+}
+
+// runBeforeReadline runs the BeforeReadline hooks
+func (s *Shell) runBeforeReadline() {
+	for _, fn := range s.loopHooks().beforeReadline {
+		fn()
+	}
+}
+
+// runAfterReadline passes the line through every AfterReadline hook
+func (s *Shell) runAfterReadline(line string) string {
+	for _, fn := range s.loopHooks().afterReadline {
+		if line = fn(line); line == "" {
+			break
+		}
+	}
+	return line
+}
+
+// runLine executes a line read by the REPL, surrounded by the execute
+// hooks. For a line run in the background, the AfterExecute hooks run once
+// its job ends, on the job's goroutine.
+func (s *Shell) runLine(line string) error {
+	hooks := s.loopHooks()
+	for _, fn := range hooks.beforeExecute {
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	afterExecute := func(err error) {
+		for _, fn := range hooks.afterExecute {
+			fn(line, err)
+		}
+	}
+	if _, background := cutBackground(line); background {
+		return s.executeLine(line, afterExecute)
+	}
+	err := s.executeLine(line, nil)
+	afterExecute(err)
+	return err
+}
+
+// withMiddleware wraps the handler running cmd with args in the registered
+// middleware. The handler receives the command and arguments the
+// middleware settled on; when they changed, the line is rebuilt from them.
+func (s *Shell) withMiddleware(cmd *cobra.Command, args []string, run func(args []string) error) shellapi.CommandHandler {
+	handler := func(c *cobra.Command, a []string) error {
+		if c == cmd && slices.Equal(a, args) {
+			return run(nil)
+		}
+		return run(append(strings.Fields(s.commandPath(c)), a...))
+	}
+	middleware := s.loopHooks().middleware
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,73 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// ErrInterrupted is returned for a command canceled with Ctrl-C
+var ErrInterrupted = errors.New("interrupted")
+
+// handleInterrupts catches Ctrl-C for as long as the shell runs, so it
+// cancels the command running rather than ending the process. A second
+// Ctrl-C for the same command, which must be ignoring its context, exits
+// with status 130 as an uncaught one would. At the prompt readline reads
+// Ctrl-C as a key instead. The returned function stops catching it.
+func (s *Shell) handleInterrupts() func() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-interrupts:
+				s.interruptMutex.Lock()
+				again := s.interrupt != nil && s.interrupted
+				if s.interrupt != nil && !again {
+					// End the line the terminal echoed ^C on
+					fmt.Fprintln(s.Stdout())
+					s.interrupted = true
+					s.interrupt(ErrInterrupted)
+				}
+				s.interruptMutex.Unlock()
+				if again {
+					fmt.Fprintln(s.Stderr())
+					s.Exit(statusInterrupted)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(interrupts)
+		close(done)
+	}
+}
+
+// runInterruptible runs a line typed at the prompt under a context Ctrl-C
+// cancels. A command returning because of it reports ErrInterrupted.
+func (s *Shell) runInterruptible(line string) error {
+	ctx, unlock := s.lockExecution(s.ctx)
+	defer unlock()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	s.interruptMutex.Lock()
+	s.interrupt = cancel
+	s.interrupted = false
+	s.interruptMutex.Unlock()
+	defer func() {
+		s.interruptMutex.Lock()
+		s.interrupt = nil
+		s.interruptMutex.Unlock()
+	}()
+
+	err := s.withContext(ctx, func() error { return s.runLine(line) })
+	if errors.Is(err, context.Canceled) && errors.Is(context.Cause(ctx), ErrInterrupted) {
+		return ErrInterrupted
+	}
+	return err
+}
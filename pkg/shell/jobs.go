@@ -0,0 +1,330 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// ErrJobKilled is returned for a job stopped with KillJob
+var ErrJobKilled = errors.New("killed")
+
+// backgroundSuffix matches a trailing & asking for a line to run as a job
+var backgroundSuffix = regexp.MustCompile(`\s+&\s*$`)
+
+// cutBackground removes a trailing & from line, reporting whether it was
+// there
+func cutBackground(line string) (string, bool) {
+	loc := backgroundSuffix.FindStringIndex(line)
+	if loc == nil {
+		return line, false
+	}
+	return line[:loc[0]], true
+}
+
+// job is a command running in the background
+type job struct {
+	id      int
+	line    string
+	cmd     *cobra.Command
+	started time.Time
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	done    chan struct{}
+	err     error
+	notify  func(error)
+
+	// Set once fg waits for the job, which then reports how it ended
+	// itself
+	foreground bool
+}
+
+// jobTable holds the running jobs
+type jobTable struct {
+	mu   sync.Mutex
+	jobs map[int]*job
+}
+
+// startJob runs a command line in the background. Cobra parses the line
+// and runs the command's hooks here, as for any command; only the
+// command's Run function, wrapped in the middleware, is moved to a
+// goroutine. A command can only run as one job at a time, so the job has
+// its flags and context to itself. notify, if not nil, is called with the
+// job's error once it ends.
+func (s *Shell) startJob(line string, notify func(error)) error {
+	args, err := s.parseLine(line)
+	if err != nil {
+		return err
+	}
+	j, run, err := s.prepareJob(nil, line, args)
+	if j == nil {
+		return err
+	}
+	j.notify = notify
+	_, cmdArgs, _ := s.rootCmd.Find(args)
+	fmt.Fprintf(s.Stdout(), "[%d] %s\n", j.id, line)
+	go s.runJob(j, j.cmd, cmdArgs, run)
+	return nil
+}
+
+// prepareJob has cobra parse args for a job, registering j, or a new job
+// for line if j is nil, and returns the job and its command's Run function.
+// The job is nil if cobra stopped before running the command, at a flag
+// error or for --help.
+func (s *Shell) prepareJob(j *job, line string, args []string) (*job, func() error, error) {
+	cmd, _, err := s.rootCmd.Find(args)
+	if err != nil || cmd == s.rootCmd {
+		return nil, nil, fmt.Errorf("unknown command: %s", line)
+	}
+	if !cmd.Runnable() {
+		return nil, nil, fmt.Errorf("%s cannot run in the background", cmd.CommandPath())
+	}
+	if err := s.checkNotJob(cmd, j); err != nil {
+		return nil, nil, err
+	}
+
+	// Swap in a Run that registers the job, before the flags it was given
+	// are reset, and records what to call
+	var registered *job
+	var run func() error
+	originalRun, originalRunE := cmd.Run, cmd.RunE
+	cmd.Run = nil
+	cmd.RunE = func(c *cobra.Command, args []string) error {
+		if j == nil {
+			registered = s.addJob(line, cmd)
+		} else {
+			s.jobs.mu.Lock()
+			j.cmd = cmd
+			s.jobs.mu.Unlock()
+			registered = j
+		}
+		run = func() error {
+			if originalRunE != nil {
+				return originalRunE(c, args)
+			}
+			originalRun(c, args)
+			return nil
+		}
+		return nil
+	}
+	err = s.execute(args, true)
+	cmd.Run, cmd.RunE = originalRun, originalRunE
+	if registered == nil {
+		return nil, nil, err
+	}
+	cmd.SetContext(registered.ctx)
+	return registered, run, nil
+}
+
+// startTask runs fn in the background as a job listed as line, with no
+// command of its own, under the job's context
+func (s *Shell) startTask(line string, fn func(ctx context.Context) error) {
+	j := s.addJob(line, nil)
+	fmt.Fprintf(s.Stdout(), "[%d] %s\n", j.id, line)
+	go s.runJob(j, nil, nil, func() error { return fn(j.ctx) })
+}
+
+// addJob registers a job for a command, numbered with the lowest free
+// number
+func (s *Shell) addJob(line string, cmd *cobra.Command) *job {
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	j := &job{line: line, cmd: cmd, started: time.Now(), ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	s.jobs.mu.Lock()
+	defer s.jobs.mu.Unlock()
+	j.id = 1
+	for s.jobs.jobs[j.id] != nil {
+		j.id++
+	}
+	s.jobs.jobs[j.id] = j
+	return j
+}
+
+// runJob runs the command of a job with its arguments, wrapped in the
+// middleware, and reports how it ended above the prompt. When the
+// middleware changes the command or its arguments, the job runs the
+// command they make up instead. A job with no command just calls run.
+func (s *Shell) runJob(j *job, cmd *cobra.Command, args []string, run func() error) {
+	body := func(rebuilt []string) error {
+		if rebuilt == nil {
+			return run()
+		}
+		ctx, unlock := s.lockExecution(j.ctx)
+		var next func() error
+		err := s.withContext(ctx, func() (err error) {
+			defer unlock()
+			_, next, err = s.prepareJob(j, j.line, rebuilt)
+			return err
+		})
+		if next == nil {
+			return err
+		}
+		return next()
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				j.err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		if cmd == nil {
+			j.err = run()
+		} else {
+			j.err = s.withMiddleware(cmd, args, body)(cmd, args)
+		}
+	}()
+	if errors.Is(j.err, context.Canceled) && errors.Is(context.Cause(j.ctx), ErrJobKilled) {
+		j.err = ErrJobKilled
+	}
+	if j.cmd != nil {
+		j.err = withExitStatus(j.ctx, j.cmd, j.err)
+	}
+	j.cancel(nil)
+
+	s.jobs.mu.Lock()
+	delete(s.jobs.jobs, j.id)
+	foreground := j.foreground
+	s.jobs.mu.Unlock()
+	if j.notify != nil {
+		j.notify(j.err)
+	}
+	close(j.done)
+
+	switch {
+	case foreground:
+	case errors.Is(j.err, ErrJobKilled):
+		s.PrintAlert(fmt.Sprintf("[%d] Killed  %s", j.id, j.line))
+	case j.err != nil:
+		s.PrintAlert(fmt.Sprintf("[%d] Failed  %s: %v", j.id, j.line, j.err))
+	default:
+		s.PrintAlert(fmt.Sprintf("[%d] Done    %s", j.id, j.line))
+	}
+}
+
+// checkNotJob fails if cmd is running as a job other than except
+func (s *Shell) checkNotJob(cmd *cobra.Command, except *job) error {
+	s.jobs.mu.Lock()
+	defer s.jobs.mu.Unlock()
+	for _, j := range s.jobs.jobs {
+		if j.cmd == cmd && j != except && cmd != nil {
+			return fmt.Errorf("%s is running as job %d", cmd.CommandPath(), j.id)
+		}
+	}
+	return nil
+}
+
+// jobCommands returns the commands running as jobs
+func (s *Shell) jobCommands() map[*cobra.Command]bool {
+	s.jobs.mu.Lock()
+	defer s.jobs.mu.Unlock()
+	cmds := make(map[*cobra.Command]bool, len(s.jobs.jobs))
+	for _, j := range s.jobs.jobs {
+		if j.cmd != nil {
+			cmds[j.cmd] = true
+		}
+	}
+	return cmds
+}
+
+// hasJobs reports whether any job is running
+func (s *Shell) hasJobs() bool {
+	s.jobs.mu.Lock()
+	defer s.jobs.mu.Unlock()
+	return len(s.jobs.jobs) > 0
+}
+
+// Jobs returns the running jobs, oldest first
+func (s *Shell) Jobs() []shellapi.Job {
+	s.jobs.mu.Lock()
+	defer s.jobs.mu.Unlock()
+	jobs := make([]shellapi.Job, 0, len(s.jobs.jobs))
+	for _, j := range s.jobs.jobs {
+		jobs = append(jobs, shellapi.Job{ID: j.id, Command: j.line, Started: j.started})
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID < jobs[b].ID })
+	return jobs
+}
+
+// ForegroundJob waits for a job to finish and returns its error. If ctx
+// is canceled first, as Ctrl-C does to the command calling it, the job is
+// killed and waited for.
+func (s *Shell) ForegroundJob(ctx context.Context, id int) error {
+	j, err := s.job(id)
+	if err != nil {
+		return err
+	}
+	s.jobs.mu.Lock()
+	j.foreground = true
+	s.jobs.mu.Unlock()
+
+	// Commands the job executes run while it is waited for
+	defer s.yieldExecution(ctx)()
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		j.cancel(ErrJobKilled)
+		<-j.done
+	}
+	return j.err
+}
+
+// KillJob cancels the context of a job. The job ends once its command
+// returns; commands ignoring their context run to completion.
+func (s *Shell) KillJob(id int) error {
+	j, err := s.job(id)
+	if err != nil {
+		return err
+	}
+	j.cancel(ErrJobKilled)
+	return nil
+}
+
+// job returns the running job with the given id
+func (s *Shell) job(id int) (*job, error) {
+	s.jobs.mu.Lock()
+	defer s.jobs.mu.Unlock()
+	j, ok := s.jobs.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("no such job: %d", id)
+	}
+	return j, nil
+}
+
+// jobWriter is what Stdout and Stderr return while jobs run. Lines written
+// while the prompt is shown come from jobs and are printed above it, with
+// an unfinished line held back until it is complete; other writes go
+// straight through.
+type jobWriter struct {
+	shell    *Shell
+	target   func() io.Writer
+	toStderr bool
+	mu       sync.Mutex
+	partial  []byte
+}
+
+func (w *jobWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data := append(w.partial, p...)
+	w.partial = nil
+	if !w.shell.rl.Terminal.IsReading() {
+		if _, err := w.target().Write(data); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		w.shell.queueAlert(string(data[:i]), w.toStderr)
+		data = data[i+1:]
+	}
+	w.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
@@ -0,0 +1,132 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// menuRows is the maximum number of candidates shown at once
+const menuRows = 10
+
+// completionMenu cycles the word under the cursor through the completion
+// candidates, listing them below the input line
+type completionMenu struct {
+	start        int
+	original     []rune
+	inserted     []rune
+	candidates   []string
+	descriptions map[string]string
+	selected     int
+	delta        int
+}
+
+// openMenu starts menu completion for the word ending at pos, given the
+// suffixes returned by the completion backend
+func (s *Shell) openMenu(line []rune, pos int, suffixes [][]rune, offset int) {
+	word := line[pos-offset : pos]
+	candidates := make([]string, 0, len(suffixes))
+	for _, suffix := range suffixes {
+		candidates = append(candidates, string(word)+string(suffix))
+	}
+	cmd, depth := s.completionCommand(string(line[:pos]))
+	s.menu = &completionMenu{
+		start:        pos - offset,
+		original:     append([]rune{}, word...),
+		inserted:     append([]rune{}, word...),
+		candidates:   candidates,
+		descriptions: completionDescriptions(cmd, string(line[:pos]), depth),
+		selected:     -1,
+	}
+}
+
+// menuKey handles a key while the menu is open. Tab and the arrows (which
+// filterInput turns into tabs) move the selection; Ctrl+G restores the typed
+// word. Any other key has already closed the menu in filterInput, keeping
+// the current candidate.
+func (s *Shell) menuKey(line []rune, key rune) ([]rune, int, bool) {
+	m := s.menu
+	switch key {
+	case readline.CharTab:
+		delta := m.delta
+		if delta == 0 {
+			delta = 1
+		}
+		m.delta = 0
+		if m.selected < 0 && delta < 0 {
+			m.selected = 0
+		}
+		m.selected = (m.selected + delta + len(m.candidates)) % len(m.candidates)
+		return m.replace(line, []rune(m.candidates[m.selected]))
+	case readline.CharBell:
+		s.menu = nil
+		return m.replace(line, m.original)
+	}
+	return nil, 0, false
+}
+
+// replace swaps the word currently inserted for another
+func (m *completionMenu) replace(line []rune, word []rune) ([]rune, int, bool) {
+	end := m.start + len(m.inserted)
+	if end > len(line) {
+		end = len(line)
+	}
+	newLine := append(append(append([]rune{}, line[:m.start]...), word...), line[end:]...)
+	m.inserted = append([]rune{}, word...)
+	return newLine, m.start + len(word), true
+}
+
+// Paint draws the line followed by the candidates
+func (m *completionMenu) Paint(s *Shell, prompt string, line []rune) []rune {
+	rows := make([]string, 0, len(m.candidates))
+	for _, candidate := range m.candidates {
+		name := strings.TrimRight(candidate, " ")
+		rows = append(rows, fmt.Sprintf("  %-24s %s", name, m.descriptions[name]))
+	}
+	return s.paintRows(prompt, line, rows, m.selected, menuRows)
+}
+
+// paintRows draws line followed by up to limit rows, highlighting the
+// selected one and scrolling to keep it visible, then moves the cursor back
+// to the end of the line
+func (s *Shell) paintRows(prompt string, line []rune, rows []string, selected, limit int) []rune {
+	start := 0
+	if selected >= limit {
+		start = selected - limit + 1
+	}
+	end := start + limit
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	width := s.terminalWidth()
+	color := s.ColorEnabled()
+	var b strings.Builder
+	b.WriteString(string(line))
+	for i := start; i < end; i++ {
+		row := rows[i]
+		if i == selected && !color {
+			// Mark the selection in the row's leading indent instead
+			row = ">" + strings.TrimPrefix(row, " ")
+		}
+		if len(row) > width-1 {
+			row = row[:width-1]
+		}
+		b.WriteString("\r\n")
+		if i == selected && color {
+			b.WriteString("\033[7m" + row + "\033[0m")
+		} else {
+			b.WriteString(row)
+		}
+	}
+
+	// Back up to the input line and the cursor column
+	if end > start {
+		fmt.Fprintf(&b, "\033[%dA\r", end-start)
+		if col := len([]rune(prompt)) + len(line); col > 0 {
+			fmt.Fprintf(&b, "\033[%dC", col)
+		}
+	}
+	return s.withStatus([]rune(b.String()))
+}
@@ -0,0 +1,119 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DefineModuleGroup names a set of modules, such as "admin" or "debug",
+// to enable or disable together. Defining a group again replaces it.
+func (s *Shell) DefineModuleGroup(name string, modules ...string) {
+	s.moduleGroups[name] = append([]string{}, modules...)
+}
+
+// ModuleGroups returns the names of the defined module groups, sorted
+func (s *Shell) ModuleGroups() []string {
+	names := make([]string, 0, len(s.moduleGroups))
+	for name := range s.moduleGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EnableModuleGroup enables every module of a group
+func (s *Shell) EnableModuleGroup(name string) error {
+	modules, ok := s.moduleGroups[name]
+	if !ok {
+		return fmt.Errorf("module group not found: %s", name)
+	}
+	var errs []error
+	for _, module := range modules {
+		if err := s.EnableModule(module); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DisableModuleGroup disables every module of a group
+func (s *Shell) DisableModuleGroup(name string) error {
+	modules, ok := s.moduleGroups[name]
+	if !ok {
+		return fmt.Errorf("module group not found: %s", name)
+	}
+	var errs []error
+	for _, module := range modules {
+		if err := s.DisableModule(module); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UseModuleProfile makes a group the active profile: its modules are
+// enabled and every other module but core is disabled. The choice is saved
+// and applied again when the next session starts.
+func (s *Shell) UseModuleProfile(name string) error {
+	modules, ok := s.moduleGroups[name]
+	if !ok {
+		return fmt.Errorf("module group not found: %s", name)
+	}
+	var errs []error
+	for _, module := range s.GetModules() {
+		if module == "core" {
+			continue
+		}
+		var err error
+		if slices.Contains(modules, module) {
+			err = s.EnableModule(module)
+		} else {
+			err = s.DisableModule(module)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, module := range modules {
+		if !slices.Contains(s.GetModules(), module) {
+			errs = append(errs, fmt.Errorf("module not found: %s", module))
+		}
+	}
+
+	s.activeProfile = name
+	if s.profilePath != "" {
+		if err := os.WriteFile(s.profilePath, []byte(name+"\n"), 0600); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ActiveModuleProfile returns the group last chosen with UseModuleProfile,
+// or "" if none
+func (s *Shell) ActiveModuleProfile() string {
+	return s.activeProfile
+}
+
+// restoreModuleProfile applies the profile saved by an earlier session, if
+// its group is still defined
+func (s *Shell) restoreModuleProfile() {
+	if s.profilePath == "" {
+		return
+	}
+	data, err := os.ReadFile(s.profilePath)
+	if err != nil {
+		return
+	}
+	name := strings.TrimSpace(string(data))
+	if _, ok := s.moduleGroups[name]; !ok {
+		return
+	}
+	if err := s.UseModuleProfile(name); err != nil {
+		s.Warn("Could not restore module profile %s: %v", name, err)
+	}
+}
@@ -0,0 +1,85 @@
+package shell
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+)
+
+// saveModuleChoice records that the user enabled or disabled a module, so
+// the next session starts the same way. Changes made before Run, such as an
+// application disabling a module by default, are not recorded.
+func (s *Shell) saveModuleChoice(moduleName string, enabled bool) {
+	if s.modulesPath == "" || !s.modulesRestored || s.restoringModules {
+		return
+	}
+	s.moduleChoices[moduleName] = enabled
+
+	names := make([]string, 0, len(s.moduleChoices))
+	for name := range s.moduleChoices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		state := "disabled"
+		if s.moduleChoices[name] {
+			state = "enabled"
+		}
+		b.WriteString(name + " " + state + "\n")
+	}
+	if err := os.WriteFile(s.modulesPath, []byte(b.String()), 0600); err != nil {
+		s.Warn("Could not save module state: %v", err)
+	}
+}
+
+// restoreModuleState applies the module profile and the module choices
+// saved by earlier sessions. Modules the user never toggled keep the state
+// the application gave them, and saved names no longer registered are
+// kept for when they are.
+func (s *Shell) restoreModuleState() {
+	s.restoringModules = true
+	defer func() {
+		s.restoringModules = false
+		s.modulesRestored = true
+	}()
+
+	s.restoreModuleProfile()
+	s.loadModuleChoices()
+	for _, module := range s.GetModules() {
+		enabled, ok := s.moduleChoices[module]
+		if !ok || module == "core" {
+			continue
+		}
+		var err error
+		if enabled {
+			err = s.EnableModule(module)
+		} else {
+			err = s.DisableModule(module)
+		}
+		if err != nil {
+			s.Warn("Could not restore module %s: %v", module, err)
+		}
+	}
+}
+
+// loadModuleChoices reads the choices saved by earlier sessions
+func (s *Shell) loadModuleChoices() {
+	if s.modulesPath == "" {
+		return
+	}
+	f, err := os.Open(s.modulesPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, state, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if ok && (state == "enabled" || state == "disabled") {
+			s.moduleChoices[name] = state == "enabled"
+		}
+	}
+}
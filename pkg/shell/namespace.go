@@ -0,0 +1,40 @@
+package shell
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/module"
+)
+
+// namespaceCommand returns the command a namespaced module's commands are
+// mounted under, named after the module and described by its metadata
+func (s *Shell) namespaceCommand(mod module.CommandModule, cmds []*cobra.Command) *cobra.Command {
+	moduleName := mod.Name()
+	short := fmt.Sprintf("Commands of the %s module", moduleName)
+	if describer, ok := mod.(module.Describer); ok && describer.Metadata().Description != "" {
+		short = describer.Metadata().Description
+	}
+	group := &cobra.Command{
+		Use:   moduleName,
+		Short: short,
+	}
+	group.AddCommand(cmds...)
+	s.namespaces[moduleName] = group
+	return group
+}
+
+// isNamespaced reports whether a module's commands are mounted under its
+// name
+func (s *Shell) isNamespaced(moduleName string) bool {
+	_, ok := s.namespaces[moduleName]
+	return ok
+}
+
+// regroupCompletion rebuilds the completion of a namespace after its
+// commands changed
+func (s *Shell) regroupCompletion(moduleName string) {
+	if s.enabledModules[moduleName] {
+		s.addCompleterNodes(s.namespaces[moduleName])
+	}
+}
@@ -0,0 +1,46 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// RunOnce executes one command line as if it were typed at the prompt,
+// without entering the main loop, and returns its exit status. A failure
+// is also returned as the error, which is not printed.
+func (s *Shell) RunOnce(command string) (int, error) {
+	if !s.modulesRestored {
+		s.restoreModuleState()
+	}
+	defer s.handleInterrupts()()
+	if command = strings.TrimSpace(command); command == "" {
+		return 0, nil
+	}
+	if command = strings.TrimSpace(s.runAfterReadline(command)); command == "" {
+		return 0, nil
+	}
+	err := s.runInterruptible(s.expandStatus(command))
+	return shellapi.ExitStatus(err), err
+}
+
+// RunArgs starts the shell as its command line arguments ask, for main
+// functions to hand os.Args[1:] to. With `-c command` it runs that command
+// and exits with its status, as `sh -c` does, for wrapper scripts and cron
+// jobs; with no arguments it runs the main loop.
+func (s *Shell) RunArgs(args []string) {
+	switch {
+	case len(args) == 0:
+		s.Run()
+	case len(args) == 2 && args[0] == "-c":
+		status, err := s.RunOnce(args[1])
+		if err != nil {
+			s.printError(s.Stderr(), err)
+		}
+		s.Exit(status)
+	default:
+		fmt.Fprintf(s.Stderr(), "usage: %s [-c command]\n", s.rootCmd.Name())
+		s.Exit(2)
+	}
+}
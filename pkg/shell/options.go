@@ -0,0 +1,280 @@
+package shell
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Option configures a Shell at construction time
+type Option func(*Shell)
+
+// WithHistoryEncryption stores the history file encrypted with a key derived
+// from the secret returned by keyFn. The secret can come from a passphrase
+// (see Passphrase) or from any other source such as the system keyring.
+func WithHistoryEncryption(keyFn KeySource) Option {
+	return func(s *Shell) {
+		s.historyKey = keyFn
+		s.historyKeyRaw = false
+	}
+}
+
+// WithHistoryKey stores the history file encrypted with a 32 byte AES-256
+// key used as is, for deployments that manage keys themselves rather than
+// deriving one from a passphrase
+func WithHistoryKey(key []byte) Option {
+	return func(s *Shell) {
+		s.historyKey = func() ([]byte, error) {
+			return key, nil
+		}
+		s.historyKeyRaw = true
+	}
+}
+
+// WithBannerFunc replaces the banner with the text returned by fn, called
+// each time the banner is shown
+func WithBannerFunc(fn func() string) Option {
+	return func(s *Shell) {
+		s.bannerFunc = fn
+	}
+}
+
+// WithBannerTemplate controls whether the banner is a text/template,
+// rendered with BannerData each time it is shown. It is off by default, so
+// banners containing {{ are printed as they are.
+func WithBannerTemplate(enabled bool) Option {
+	return func(s *Shell) {
+		s.templateBanner = enabled
+	}
+}
+
+// WithStdin reads input from r instead of os.Stdin
+func WithStdin(r io.Reader) Option {
+	return func(s *Shell) {
+		s.stdin = r
+	}
+}
+
+// WithStdout sends the output of the shell and its commands to w instead
+// of os.Stdout, for tests, logging tees or remote sessions
+func WithStdout(w io.Writer) Option {
+	return func(s *Shell) {
+		s.stdout = w
+	}
+}
+
+// WithStderr sends errors to w instead of os.Stderr
+func WithStderr(w io.Writer) Option {
+	return func(s *Shell) {
+		s.stderr = w
+	}
+}
+
+// WithFeatures turns on feature flags at construction, before any module
+// beyond core is registered. Features can also be listed, comma separated,
+// in the <NAME>_FEATURES environment variable, e.g. MYSHELL_FEATURES.
+func WithFeatures(names ...string) Option {
+	return func(s *Shell) {
+		for _, name := range names {
+			s.SetFeature(name, true)
+		}
+	}
+}
+
+// WithConfigFile reads module configuration from the JSON file at path.
+// The section under "modules" named after a module is passed to its
+// Configure method when it is registered:
+//
+//	{"modules": {"timer": {"format": "15:04"}}}
+func WithConfigFile(path string) Option {
+	return func(s *Shell) {
+		s.configPath = path
+	}
+}
+
+// WithNamespaces mounts the commands of the named modules under a command
+// named after each module, so `timer reset` runs the timer module's reset.
+// This avoids collisions between modules and keeps large shells navigable;
+// help and completion follow the hierarchy. The core module stays flat.
+func WithNamespaces(moduleNames ...string) Option {
+	return func(s *Shell) {
+		for _, name := range moduleNames {
+			s.namespaced[name] = true
+		}
+	}
+}
+
+// WithConflictPolicy sets what RegisterModule does when a module's command
+// uses a name or alias another module already has. The default is
+// ConflictReject.
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(s *Shell) {
+		s.conflictPolicy = policy
+	}
+}
+
+// WithModulePersistence controls whether the modules the user disables
+// stay disabled in the next session. It is on by default.
+func WithModulePersistence(enabled bool) Option {
+	return func(s *Shell) {
+		s.modulesPersistence = enabled
+	}
+}
+
+// WithCapabilityChecks restricts every module but core and user to the
+// capabilities granted with GrantCapabilities. Modules declaring
+// capabilities they were not granted are not registered, and ShellAPI calls
+// needing a capability the module lacks fail with ErrCapabilityDenied.
+func WithCapabilityChecks(enabled bool) Option {
+	return func(s *Shell) {
+		s.capabilityChecks = enabled
+	}
+}
+
+// WithContext sets the context every command's context derives from, so
+// canceling it cancels the command running and those run afterwards
+func WithContext(ctx context.Context) Option {
+	return func(s *Shell) {
+		s.ctx = ctx
+	}
+}
+
+// WithCommandTimeout cancels the context of commands running longer than
+// timeout, unless they set their own with shellapi.AnnotationTimeout. The
+// default, 0, sets no limit; the command-timeout setting changes it at
+// runtime.
+func WithCommandTimeout(timeout time.Duration) Option {
+	return func(s *Shell) {
+		s.commandTimeout.Store(int64(timeout))
+	}
+}
+
+// WithHistoryFile sets where history is saved between sessions. An empty
+// path keeps history for the current session only. The default is
+// history under $XDG_DATA_HOME/<name>, or ~/.local/share/<name>.
+func WithHistoryFile(path string) Option {
+	return func(s *Shell) {
+		s.historyPath = path
+		s.historyPathSet = true
+	}
+}
+
+// WithHistoryFormat sets how the history file is written. HistoryRecords,
+// the default, keeps the time, duration and exit status of each command;
+// HistoryText writes bare lines as readline does. Text files from earlier
+// versions load in either format.
+func WithHistoryFormat(format HistoryFormat) Option {
+	return func(s *Shell) {
+		s.historyFormat = format
+	}
+}
+
+// WithHistoryLimit sets how many entries are kept, 500 by default. The
+// history file is trimmed back to the newest entries as it grows past the
+// limit.
+func WithHistoryLimit(n int) Option {
+	return func(s *Shell) {
+		if n > 0 {
+			s.historyLimit = n
+		}
+	}
+}
+
+// WithHistory controls whether entered commands are remembered at all. It
+// is on by default; when off nothing is recalled or written to disk.
+func WithHistory(enabled bool) Option {
+	return func(s *Shell) {
+		s.historyDisabled = !enabled
+	}
+}
+
+// WithHistoryExpansion controls bash-style history references: !! for the
+// previous command and !prefix for the latest one starting with prefix. It
+// is on by default and can be changed at runtime with the history-expansion
+// setting.
+func WithHistoryExpansion(enabled bool) Option {
+	return func(s *Shell) {
+		s.historyExpansion.Store(enabled)
+	}
+}
+
+// WithHistoryIgnoreDups leaves a command out of the history when it
+// repeats the previous entry. It can be changed at runtime with the
+// history-ignore-dups setting.
+func WithHistoryIgnoreDups(enabled bool) Option {
+	return func(s *Shell) {
+		s.historyIgnoreDups.Store(enabled)
+	}
+}
+
+// WithHistoryIgnoreSpace leaves commands typed with a leading space out of
+// the history, a quick way to keep one-off secrets from being saved. It can
+// be changed at runtime with the history-ignore-space setting.
+func WithHistoryIgnoreSpace(enabled bool) Option {
+	return func(s *Shell) {
+		s.historyIgnoreSpace.Store(enabled)
+	}
+}
+
+// WithHistoryInMemory starts the shell with in-memory history: commands can
+// be recalled during the session but are never written to disk. It can be
+// changed at runtime with SetHistoryInMemory.
+func WithHistoryInMemory(enabled bool) Option {
+	return func(s *Shell) {
+		s.historyInMemory.Store(enabled)
+	}
+}
+
+// WithFuzzyCompletion makes tab completion fall back to fuzzy matching when
+// nothing starts with the typed word, so `dmod` completes `disable-module`.
+// It can be changed at runtime with the fuzzy-completion setting.
+func WithFuzzyCompletion(enabled bool) Option {
+	return func(s *Shell) {
+		s.fuzzyCompletion.Store(enabled)
+	}
+}
+
+// WithCompleter replaces the completion backend built from the command
+// tree, see SetCompleter
+func WithCompleter(c Completer) Option {
+	return func(s *Shell) {
+		s.customCompleter = c
+	}
+}
+
+// WithCompletionCase sets how letter case is compared in tab completion.
+// SmartCase ignores case unless the typed word contains an uppercase
+// letter. It can be changed at runtime with the completion-case setting.
+func WithCompletionCase(mode CompletionCase) Option {
+	return func(s *Shell) {
+		s.completionCase.Store(int32(mode))
+	}
+}
+
+// WithCompletionMenu makes tab open a menu of the candidates when several
+// match. Tab and the arrows cycle the word through them, Ctrl+G restores
+// what was typed. It can be changed at runtime with the completion-menu
+// setting.
+func WithCompletionMenu(enabled bool) Option {
+	return func(s *Shell) {
+		s.menuCompletion.Store(enabled)
+	}
+}
+
+// WithAutosuggest controls the grey history suggestion shown after the
+// cursor, accepted with the right arrow. It is on by default and can be
+// changed at runtime with the autosuggest setting.
+func WithAutosuggest(enabled bool) Option {
+	return func(s *Shell) {
+		s.autosuggest.Store(enabled)
+	}
+}
+
+// WithPrefixMatching lets unambiguous prefixes resolve to commands, so
+// `mod` runs `modules`. It can be changed at runtime with the
+// prefix-matching setting.
+func WithPrefixMatching(enabled bool) Option {
+	return func(s *Shell) {
+		s.prefixMatching.Store(enabled)
+	}
+}
@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// OutputLevel is the severity of a line printed through the leveled helpers
+type OutputLevel int32
+
+const (
+	LevelInfo OutputLevel = iota
+	LevelSuccess
+	LevelWarn
+	LevelError
+)
+
+// levelNames maps setting values to output levels
+var levelNames = map[string]OutputLevel{
+	"info":    LevelInfo,
+	"success": LevelSuccess,
+	"warn":    LevelWarn,
+	"error":   LevelError,
+}
+
+// levelPrefixes tags each line with its severity
+var levelPrefixes = map[OutputLevel]string{
+	LevelInfo:    "[*] ",
+	LevelSuccess: "[+] ",
+	LevelWarn:    "[!] ",
+	LevelError:   "[-] ",
+}
+
+// levelColors color each prefix when colors are enabled
+var levelColors = map[OutputLevel]string{
+	LevelInfo:    "\033[34m",
+	LevelSuccess: "\033[32m",
+	LevelWarn:    "\033[33m",
+	LevelError:   "\033[31m",
+}
+
+// ParseOutputLevel converts a level name such as "warn" to an OutputLevel
+func ParseOutputLevel(name string) (OutputLevel, error) {
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown output level: %s (expected info, success, warn or error)", name)
+	}
+	return level, nil
+}
+
+// outputFilter holds the minimum level that is printed
+type outputFilter struct {
+	minLevel atomic.Int32
+}
+
+// registerOutputSettings exposes the output filter as the min-output-level setting
+func (s *Shell) registerOutputSettings() {
+	s.RegisterSetting("min-output-level", "Lowest severity printed by modules (info, success, warn, error)", "info",
+		func(value string) error {
+			level, err := ParseOutputLevel(value)
+			if err != nil {
+				return err
+			}
+			s.output.minLevel.Store(int32(level))
+			return nil
+		})
+}
+
+// printLevel prints a tagged line if its level passes the filter. Errors go
+// to stderr. While input is being read the line is printed as an alert
+// above it, so background goroutines can report without garbling the line.
+func (s *Shell) printLevel(level OutputLevel, format string, args ...interface{}) {
+	if int32(level) < s.output.minLevel.Load() {
+		return
+	}
+	prefix := levelPrefixes[level]
+	if s.ColorEnabled() {
+		prefix = levelColors[level] + strings.TrimSpace(prefix) + "\033[0m "
+	}
+	line := prefix + s.Redact(fmt.Sprintf(format, args...))
+	toStderr := level == LevelError
+	switch {
+	case s.rl.Terminal.IsReading():
+		s.queueAlert(line, toStderr)
+	case toStderr:
+		fmt.Fprintln(s.Stderr(), line)
+	default:
+		fmt.Fprintln(s.Stdout(), line)
+	}
+}
+
+// Info prints an informational line
+func (s *Shell) Info(format string, args ...interface{}) {
+	s.printLevel(LevelInfo, format, args...)
+}
+
+// Success prints a line reporting a successful operation
+func (s *Shell) Success(format string, args ...interface{}) {
+	s.printLevel(LevelSuccess, format, args...)
+}
+
+// Warn prints a warning line
+func (s *Shell) Warn(format string, args ...interface{}) {
+	s.printLevel(LevelWarn, format, args...)
+}
+
+// Error prints an error line to stderr
+func (s *Shell) Error(format string, args ...interface{}) {
+	s.printLevel(LevelError, format, args...)
+}
@@ -0,0 +1,139 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// moreSuffix matches a trailing `| more` asking for a line to be paged
+var moreSuffix = regexp.MustCompile(`\s*\|\s*more\s*$`)
+
+// cutMore removes a trailing `| more` from line, reporting whether it was there
+func cutMore(line string) (string, bool) {
+	loc := moreSuffix.FindStringIndex(line)
+	if loc == nil {
+		return line, false
+	}
+	return line[:loc[0]], true
+}
+
+// pagedCommand reports whether cmd itself is annotated with
+// shellapi.AnnotationPager. Subcommands are not paged with their parent,
+// as they may prompt or run for long.
+func pagedCommand(cmd *cobra.Command) bool {
+	_, ok := cmd.Annotations[shellapi.AnnotationPager]
+	return ok
+}
+
+// executePaged runs fn and shows its output through the pager when it is
+// taller than the terminal. Output goes straight through when stdout isn't
+// a terminal, or when commands aren't typed at one.
+func (s *Shell) executePaged(fn func() error) error {
+	if !s.stdoutIsTerminal() || !s.Interactive() {
+		return fn()
+	}
+	output, err := s.captureOutput(fn, false)
+	s.page(output)
+	return err
+}
+
+// pager shows output a screen at a time above a status line
+type pager struct {
+	shell *Shell
+	lines []string
+	top   int
+	rows  int
+}
+
+// page prints output, a screen at a time if it doesn't fit the terminal.
+// Space and b move a page, the arrows, j, k and Enter a line, g and G jump
+// to either end, and q quits, as does moving past the end.
+func (s *Shell) page(output string) {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		lines = append(lines, wrapLine(line, s.Width())...)
+	}
+	rows := s.Height() - 1
+	if len(lines) <= rows || rows < 1 {
+		fmt.Fprint(s.Stdout(), output)
+		return
+	}
+
+	s.pager = &pager{shell: s, lines: lines, rows: rows}
+	fmt.Fprint(s.Stdout(), s.pager.window())
+
+	// Swap in the pager for one line, erasing the status once done
+	cfg := s.rl.Config
+	prompt := s.inputPrompt()
+	autoComplete, painter := cfg.AutoComplete, cfg.Painter
+	cfg.AutoComplete, cfg.Painter, cfg.UniqueEditLine = nil, painterFunc(func(line []rune, pos int) []rune { return s.withStatus(nil) }), true
+	s.rl.SetPrompt(s.pager.status())
+	defer func() {
+		cfg.AutoComplete, cfg.Painter, cfg.UniqueEditLine = autoComplete, painter, false
+		s.rl.SetPrompt(prompt)
+		s.pager = nil
+	}()
+	s.rl.Readline()
+}
+
+// pagerKey handles a key while the pager is open. Keys ending the pager
+// are turned into Enter; every other key is consumed.
+func (p *pager) pagerKey(r rune) (rune, bool) {
+	last := len(p.lines) - p.rows
+	switch r {
+	case ' ', 'f':
+		if p.top == last {
+			return readline.CharEnter, true
+		}
+		p.scroll(p.rows)
+	case 'b':
+		p.scroll(-p.rows)
+	case readline.CharEnter, readline.CharCtrlJ, readline.CharNext, 'j':
+		if p.top == last {
+			return readline.CharEnter, true
+		}
+		p.scroll(1)
+	case readline.CharPrev, 'k':
+		p.scroll(-1)
+	case 'g':
+		p.scroll(-len(p.lines))
+	case 'G':
+		p.scroll(len(p.lines))
+	case 'q', readline.CharInterrupt, readline.CharDelete:
+		return readline.CharEnter, true
+	}
+	return r, false
+}
+
+// scroll moves the window by n lines and redraws it in place
+func (p *pager) scroll(n int) {
+	top := min(max(p.top+n, 0), len(p.lines)-p.rows)
+	if top == p.top {
+		return
+	}
+	p.top = top
+	p.shell.rl.SetPrompt(p.status())
+	p.shell.rl.Write([]byte(fmt.Sprintf("\033[%dA\r\033[J%s", p.rows, p.window())))
+}
+
+// window returns the lines currently on screen
+func (p *pager) window() string {
+	return strings.Join(p.lines[p.top:p.top+p.rows], "\n") + "\n"
+}
+
+// status returns the line shown below the window
+func (p *pager) status() string {
+	status := "(END)"
+	if bottom := p.top + p.rows; bottom < len(p.lines) {
+		status = fmt.Sprintf("--More-- (%d%%)", bottom*100/len(p.lines))
+	}
+	if p.shell.ColorEnabled() {
+		return "\033[7m" + status + "\033[0m "
+	}
+	return status + " "
+}
@@ -0,0 +1,237 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+)
+
+// ctrlP is the raw byte sent by the terminal for Ctrl+P
+const ctrlP = 0x10
+
+// paletteKey replaces Ctrl+P in the input stream. Readline maps both Ctrl+P
+// and the up arrow to the same rune, so the raw byte is translated before
+// readline sees it.
+const paletteKey = '\uE000'
+
+// paletteRows is the maximum number of matches shown at once
+const paletteRows = 10
+
+// paletteKeyReader translates Ctrl+P into paletteKey
+type paletteKeyReader struct {
+	r       io.Reader
+	pending []byte
+}
+
+func (k *paletteKeyReader) Read(p []byte) (int, error) {
+	if len(k.pending) == 0 {
+		buf := make([]byte, len(p))
+		n, err := k.r.Read(buf)
+		for _, b := range buf[:n] {
+			if b == ctrlP {
+				k.pending = append(k.pending, string(paletteKey)...)
+			} else {
+				k.pending = append(k.pending, b)
+			}
+		}
+		if len(k.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, k.pending)
+	k.pending = k.pending[n:]
+	return n, nil
+}
+
+// paletteEntry is a command listed in the palette
+type paletteEntry struct {
+	path     string
+	short    string
+	takesArg bool
+}
+
+// palette renders the filtered command list below the query line
+type palette struct {
+	shell    *Shell
+	entries  []paletteEntry
+	matches  []paletteEntry
+	selected int
+	query    string
+	prompt   string
+}
+
+// filterInput handles selection keys before readline processes them
+func (s *Shell) filterInput(r rune) (rune, bool) {
+	if s.picker != nil {
+		return s.picker.pickerKey(r)
+	}
+	if s.pager != nil {
+		return s.pager.pagerKey(r)
+	}
+	if s.secret {
+		return secretKey(r)
+	}
+	if s.asking {
+		return r, true
+	}
+	if r == readline.CharCtrlL && s.palette == nil && s.menu == nil {
+		s.ClearScreen()
+		return r, false
+	}
+	if r == paletteKey && s.palette == nil {
+		// Finish the current line without keeping it on screen;
+		// Run opens the palette with what was typed as the query
+		s.paletteRequested = true
+		s.rl.Config.UniqueEditLine = true
+		return readline.CharEnter, true
+	}
+	if s.palette == nil {
+		if s.menu != nil {
+			return s.filterMenuInput(r), true
+		}
+		return r, true
+	}
+
+	switch r {
+	case readline.CharPrev, paletteKey:
+		s.palette.move(-1)
+		return r, false
+	case readline.CharNext:
+		s.palette.move(1)
+		return r, false
+	}
+	return r, true
+}
+
+// filterMenuInput turns the arrows into tabs while the completion menu is
+// open, so the listener sees them and moves the selection. Other keys close
+// the menu before they are processed, so it is not drawn again.
+func (s *Shell) filterMenuInput(r rune) rune {
+	switch r {
+	case readline.CharNext:
+		s.menu.delta = 1
+		return readline.CharTab
+	case readline.CharPrev:
+		s.menu.delta = -1
+		return readline.CharTab
+	case readline.CharTab, readline.CharBell:
+		return r
+	}
+	s.menu = nil
+	return r
+}
+
+// runPalette lets the user pick a command, starting from query. It returns
+// the chosen command and whether it should run immediately.
+func (s *Shell) runPalette(query string) (string, bool) {
+	s.palette = &palette{
+		shell:   s,
+		entries: paletteEntries(s.rootCmd, ""),
+		prompt:  "palette> ",
+	}
+	s.palette.filter(query)
+
+	// Swap in the palette for one line, erasing it once submitted
+	cfg := s.rl.Config
+	prompt := s.inputPrompt()
+	autoComplete, painter := cfg.AutoComplete, cfg.Painter
+	cfg.AutoComplete, cfg.Painter, cfg.UniqueEditLine = nil, s.palette, true
+	s.rl.SetPrompt(s.palette.prompt)
+	defer func() {
+		cfg.AutoComplete, cfg.Painter, cfg.UniqueEditLine = autoComplete, painter, false
+		s.rl.SetPrompt(prompt)
+		s.palette = nil
+	}()
+
+	_, err := s.rl.ReadlineWithDefault(query)
+	if err != nil || len(s.palette.matches) == 0 {
+		return "", false
+	}
+	entry := s.palette.matches[s.palette.selected]
+	if entry.takesArg {
+		return entry.path + " ", false
+	}
+	return entry.path, true
+}
+
+// paletteEntries lists every visible command below cmd
+func paletteEntries(cmd *cobra.Command, prefix string) []paletteEntry {
+	entries := []paletteEntry{}
+	for _, child := range cmd.Commands() {
+		if child.Hidden {
+			continue
+		}
+		path := prefix + child.Name()
+		entries = append(entries, paletteEntry{
+			path:     path,
+			short:    child.Short,
+			takesArg: len(strings.Fields(child.Use)) > 1,
+		})
+		entries = append(entries, paletteEntries(child, path+" ")...)
+	}
+	return entries
+}
+
+// filter narrows the entries to those matching query, best matches first
+func (p *palette) filter(query string) {
+	type scored struct {
+		entry paletteEntry
+		score int
+	}
+	results := []scored{}
+	for _, entry := range p.entries {
+		score, ok := fuzzyMatch(query, entry.path)
+		if !ok {
+			// Descriptions match too, ranked below any name match
+			if _, ok := fuzzyMatch(query, entry.short); !ok {
+				continue
+			}
+			score = -1
+		}
+		results = append(results, scored{entry, score})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+
+	p.matches = p.matches[:0]
+	for _, result := range results {
+		p.matches = append(p.matches, result.entry)
+	}
+	p.query = query
+	p.selected = 0
+}
+
+// move changes the selected match, wrapping around the list
+func (p *palette) move(delta int) {
+	if len(p.matches) == 0 {
+		return
+	}
+	p.selected = (p.selected + delta + len(p.matches)) % len(p.matches)
+}
+
+// Paint draws the query followed by the matches, then moves the cursor back
+// to the end of the query line
+func (p *palette) Paint(line []rune, pos int) []rune {
+	query := string(line)
+	if strings.HasSuffix(query, "\n") {
+		// The line was submitted, leave the list off the final output
+		return p.shell.withStatus(line)
+	}
+	if query != p.query {
+		p.filter(query)
+	}
+
+	if len(p.matches) == 0 {
+		return p.shell.paintRows(p.prompt, line, []string{"  (no matching commands)"}, -1, paletteRows)
+	}
+	rows := make([]string, 0, len(p.matches))
+	for _, entry := range p.matches {
+		rows = append(rows, fmt.Sprintf("  %-24s %s", entry.path, entry.short))
+	}
+	return p.shell.paintRows(p.prompt, line, rows, p.selected, paletteRows)
+}
@@ -0,0 +1,157 @@
+package shell
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// parseLine splits an input line into arguments for the root command.
+// Commands annotated with shellapi.AnnotationRawArgs receive the rest of
+// the line after their name as a single argument; the arguments of others
+// are split as splitArgs does.
+func (s *Shell) parseLine(line string) ([]string, error) {
+	cmd := s.rootCmd
+	rest := line
+	path := []string{}
+
+	// Walk down the command tree resolving command names
+	for rest != "" {
+		name, remainder, _ := strings.Cut(rest, " ")
+		child, err := s.resolveSubcommand(cmd, name)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			break
+		}
+		if child.Name() != name && !child.HasAlias(name) {
+			// Expand the abbreviation so cobra sees the full name
+			name = child.Name()
+		}
+		cmd = child
+		path = append(path, name)
+		rest = remainder
+
+		if isRawCommand(cmd) {
+			cmd.DisableFlagParsing = true
+			rest = strings.TrimLeft(rest, " ")
+			if rest == "" {
+				return path, nil
+			}
+			return append(path, rest), nil
+		}
+	}
+
+	args, err := splitArgs(rest)
+	if err != nil {
+		return nil, err
+	}
+	return append(path, args...), nil
+}
+
+// splitArgs splits text into arguments at runs of spaces and tabs. Text in
+// single quotes is taken as it is, and text in double quotes too but for
+// \" and \\, so arguments can hold spaces and quotes.
+func splitArgs(text string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case ' ', '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case '\'':
+			end := strings.IndexByte(text[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote: %s", text[i:])
+			}
+			arg.WriteString(text[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case '"':
+			j := i + 1
+			for ; j < len(text) && text[j] != '"'; j++ {
+				if text[j] == '\\' && j+1 < len(text) && (text[j+1] == '"' || text[j+1] == '\\') {
+					j++
+				}
+				arg.WriteByte(text[j])
+			}
+			if j == len(text) {
+				return nil, fmt.Errorf("unterminated quote: %s", text[i:])
+			}
+			i = j
+			inArg = true
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// resolveSubcommand finds the child of cmd named by name. When prefix
+// matching is enabled an unambiguous prefix also resolves; an ambiguous
+// one returns an error listing the candidates.
+func (s *Shell) resolveSubcommand(cmd *cobra.Command, name string) (*cobra.Command, error) {
+	child := findSubcommand(cmd, name)
+	if child != nil || !s.prefixMatching.Load() || name == "" || strings.HasPrefix(name, "-") {
+		return child, nil
+	}
+
+	var matches []*cobra.Command
+	for _, candidate := range cmd.Commands() {
+		if candidate.Hidden {
+			continue
+		}
+		if strings.HasPrefix(candidate.Name(), name) {
+			matches = append(matches, candidate)
+			continue
+		}
+		for _, alias := range candidate.Aliases {
+			if strings.HasPrefix(alias, name) {
+				matches = append(matches, candidate)
+				break
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	}
+
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, match.Name())
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("ambiguous command %q, could be: %s", name, strings.Join(names, ", "))
+}
+
+// findSubcommand returns the direct child of cmd matching name or one of its aliases
+func findSubcommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, child := range cmd.Commands() {
+		if child.Name() == name || child.HasAlias(name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// isRawCommand reports whether a command wants its arguments untokenized
+func isRawCommand(cmd *cobra.Command) bool {
+	return cmd.Annotations[shellapi.AnnotationRawArgs] == "true"
+}
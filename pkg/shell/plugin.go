@@ -0,0 +1,83 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/module"
+)
+
+// PluginSymbol is the function a plugin exports to create its module
+const PluginSymbol = "New"
+
+// LoadPlugin opens a Go plugin built with `go build -buildmode=plugin` and
+// registers the module returned by its exported New function, which must
+// be a func() module.CommandModule. Plugins let third parties extend a
+// shipped shell without recompiling it; they must be built with the same
+// Go version and module versions as the shell.
+func (s *Shell) LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	symbol, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return err
+	}
+	newModule, ok := symbol.(func() module.CommandModule)
+	if !ok {
+		return fmt.Errorf("%s: %s is a %T, not a func() module.CommandModule", path, PluginSymbol, symbol)
+	}
+
+	mod := newModule()
+	if mod == nil {
+		return fmt.Errorf("%s: %s returned no module", path, PluginSymbol)
+	}
+	if err := s.RegisterModule(mod); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadModulesFromDir loads every module found in dir, in file name order:
+// Go plugins ending in .so, and JSON manifests ending in .json describing
+// modules whose commands run external programs. A file that fails to load
+// does not stop the others; the failures are returned together, each
+// naming its file.
+func (s *Shell) LoadModulesFromDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		switch filepath.Ext(name) {
+		case ".so":
+			if err := s.LoadPlugin(path); err != nil {
+				errs = append(errs, err)
+			}
+		case ".json":
+			mod, err := loadManifest(path)
+			if err == nil {
+				err = s.RegisterModule(mod)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,223 @@
+package shell
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// profileVersion is the format version written to exported profiles
+const profileVersion = 1
+
+// stateBundle is the on-disk form of exported shared state
+type stateBundle struct {
+	Version int                        `json:"version"`
+	State   map[string]json.RawMessage `json:"state"`
+}
+
+// profileBundle is the on-disk form of a shell profile
+type profileBundle struct {
+	Version  int                        `json:"version"`
+	Settings map[string]string          `json:"settings"`
+	Features []string                   `json:"features,omitempty"`
+	State    map[string]json.RawMessage `json:"state"`
+}
+
+// ExportProfile writes the current settings and shared state to path.
+// State values that cannot be encoded as JSON are skipped with a warning,
+// and those whose MarshalState returns ErrSkipState silently.
+func (s *Shell) ExportProfile(path string) error {
+	bundle := profileBundle{
+		Version:  profileVersion,
+		Settings: make(map[string]string),
+		State:    s.encodeState(),
+	}
+	for _, setting := range s.GetSettings() {
+		bundle.Settings[setting.Name] = setting.Value
+	}
+	for _, feature := range s.Features() {
+		if feature.Enabled {
+			bundle.Features = append(bundle.Features, feature.Name)
+		}
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ImportProfile applies the settings and shared state stored in path.
+// State keys that already exist are decoded into the type of their current
+// value so modules keep seeing the types they stored.
+func (s *Shell) ImportProfile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var bundle profileBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
+	if bundle.Version != profileVersion {
+		return fmt.Errorf("unsupported profile version %d", bundle.Version)
+	}
+
+	var errs []error
+	for name, value := range bundle.Settings {
+		if err := s.SetSetting(name, value); err != nil {
+			errs = append(errs, fmt.Errorf("setting %s: %w", name, err))
+		}
+	}
+	for _, name := range bundle.Features {
+		s.SetFeature(name, true)
+	}
+	errs = append(errs, s.applyState(bundle.State)...)
+	return errors.Join(errs...)
+}
+
+// ExportState writes the shared state alone to path as JSON, to share a
+// configured session, such as saved hosts and variables, with teammates.
+// Values are encoded as ExportProfile encodes them.
+func (s *Shell) ExportState(path string) error {
+	bundle := stateBundle{Version: profileVersion, State: s.encodeState()}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ImportState sets the state keys stored in path by ExportState, keeping
+// other keys. Values are decoded as ImportProfile decodes them; keys that
+// cannot be restored are reported together.
+func (s *Shell) ImportState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var bundle stateBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return fmt.Errorf("invalid state file: %w", err)
+	}
+	if bundle.Version != profileVersion {
+		return fmt.Errorf("unsupported state file version %d", bundle.Version)
+	}
+	return errors.Join(s.applyState(bundle.State)...)
+}
+
+// encodeState returns the JSON saved for every live state key but the last
+// status. Values that cannot be encoded are skipped with a warning, and
+// those whose MarshalState returns ErrSkipState silently.
+func (s *Shell) encodeState() map[string]json.RawMessage {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	state := make(map[string]json.RawMessage)
+	for key, value := range s.State {
+		if s.stateExpiry[key].expired() || key == shellapi.StateLastStatus {
+			continue
+		}
+		data, err := encodeStateValue(value)
+		if errors.Is(err, shellapi.ErrSkipState) {
+			continue
+		}
+		if err != nil {
+			s.Warn("Skipping state key %s: %v", key, err)
+			continue
+		}
+		state[key] = data
+	}
+	return state
+}
+
+// applyState decodes and sets saved state keys, returning an error for
+// each key that could not be restored. The last status of the session
+// that saved them is not restored.
+func (s *Shell) applyState(state map[string]json.RawMessage) []error {
+	var errs []error
+	for key, raw := range state {
+		if key == shellapi.StateLastStatus {
+			continue
+		}
+		value, err := s.decodeStateValue(key, raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("state %s: %w", key, err))
+			continue
+		}
+		if err := s.SetState(key, value); err != nil {
+			errs = append(errs, fmt.Errorf("state %s: %w", key, err))
+		}
+	}
+	return errs
+}
+
+// RegisterStateDecoder sets the function restoring the value of key from
+// saved state, for keys that may not be set yet when a profile is imported
+func (s *Shell) RegisterStateDecoder(key string, decode func(data []byte) (interface{}, error)) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.stateDecoders[key] = decode
+}
+
+// encodeStateValue returns the JSON saved for a state value, asking values
+// implementing StateMarshaler for their own
+func encodeStateValue(value interface{}) (json.RawMessage, error) {
+	if marshaler, ok := value.(shellapi.StateMarshaler); ok {
+		data, err := marshaler.MarshalState()
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("MarshalState returned invalid JSON")
+		}
+		return data, nil
+	}
+	return json.Marshal(value)
+}
+
+// decodeStateValue restores a saved value with the key's registered
+// decoder, or into the type of the key's current value, using its
+// StateUnmarshaler if it has one. New keys get generic JSON types.
+func (s *Shell) decodeStateValue(key string, raw json.RawMessage) (interface{}, error) {
+	s.stateMutex.RLock()
+	decode := s.stateDecoders[key]
+	s.stateMutex.RUnlock()
+	if decode != nil {
+		return decode(raw)
+	}
+
+	current, ok := s.GetState(key)
+	if ok && current != nil {
+		t := reflect.TypeOf(current)
+		if t.Kind() == reflect.Pointer {
+			// Restore into a new value rather than the live one
+			ptr := reflect.New(t.Elem())
+			if unmarshaler, ok := ptr.Interface().(shellapi.StateUnmarshaler); ok {
+				if err := unmarshaler.UnmarshalState(raw); err != nil {
+					return nil, err
+				}
+				return ptr.Interface(), nil
+			}
+		}
+		ptr := reflect.New(t)
+		if unmarshaler, ok := ptr.Interface().(shellapi.StateUnmarshaler); ok {
+			if err := unmarshaler.UnmarshalState(raw); err != nil {
+				return nil, err
+			}
+			return ptr.Elem().Interface(), nil
+		}
+		if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+			return nil, err
+		}
+		return ptr.Elem().Interface(), nil
+	}
+
+	var value interface{}
+	err := json.Unmarshal(raw, &value)
+	return value, err
+}
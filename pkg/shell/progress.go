@@ -0,0 +1,200 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// maxBarWidth caps the width of the bar itself on wide terminals
+const maxBarWidth = 40
+
+// spinnerInterval is how often a spinner advances a frame
+const spinnerInterval = 100 * time.Millisecond
+
+// spinnerFrames are drawn in turn while a spinner runs
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// drawStatus overwrites the current terminal line with a status line. It
+// draws nothing while readline is reading, as the line holds the input.
+func (s *Shell) drawStatus(line string) {
+	if s.rl.Terminal.IsReading() {
+		return
+	}
+	if width := s.terminalWidth() - 1; len([]rune(line)) > width {
+		line = string([]rune(line)[:width])
+	}
+	fmt.Fprintf(s.Stdout(), "\r%s\033[K", line)
+}
+
+// finishStatus replaces the status line with a final message, or prints it
+// above the input if readline is reading
+func (s *Shell) finishStatus(line string, interactive bool) {
+	switch {
+	case s.rl.Terminal.IsReading():
+		if line != "" {
+			s.queueAlert(line, false)
+		}
+	case interactive && line == "":
+		fmt.Fprint(s.Stdout(), "\r\033[K")
+	case interactive:
+		fmt.Fprintf(s.Stdout(), "\r%s\033[K\n", line)
+	default:
+		if line != "" {
+			fmt.Fprintln(s.Stdout(), line)
+		}
+	}
+}
+
+// progressBar is the ProgressBar returned by NewProgressBar
+type progressBar struct {
+	shell       *Shell
+	mu          sync.Mutex
+	total       int
+	current     int
+	drawn       time.Time
+	interactive bool
+	done        bool
+}
+
+// NewProgressBar starts a progress bar counting up to total. Redraws are
+// limited to the render interval, so it can be updated for every item.
+func (s *Shell) NewProgressBar(total int) shellapi.ProgressBar {
+	p := &progressBar{
+		shell:       s,
+		total:       max(total, 0),
+		interactive: s.stdoutIsTerminal(),
+	}
+	if p.interactive {
+		p.draw()
+	}
+	return p
+}
+
+// Add advances the bar by n
+func (p *progressBar) Add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.set(p.current + n)
+}
+
+// Set moves the bar to n
+func (p *progressBar) Set(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.set(n)
+}
+
+// set updates the count, redrawing at most once per render interval
+func (p *progressBar) set(n int) {
+	if p.done {
+		return
+	}
+	p.current = min(max(n, 0), p.total)
+	if p.interactive && time.Since(p.drawn) >= renderInterval {
+		p.draw()
+	}
+}
+
+// Done leaves the bar at its final state on its own line
+func (p *progressBar) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
+	p.done = true
+	p.shell.finishStatus(p.line(), p.interactive)
+}
+
+// draw writes the bar to the terminal line
+func (p *progressBar) draw() {
+	p.drawn = time.Now()
+	p.shell.drawStatus(p.line())
+}
+
+// line formats the bar and its counts to fit the terminal
+func (p *progressBar) line() string {
+	percent := 100
+	if p.total > 0 {
+		percent = p.current * 100 / p.total
+	}
+	counts := fmt.Sprintf(" %d/%d %3d%%", p.current, p.total, percent)
+	width := min(p.shell.terminalWidth()-len(counts)-3, maxBarWidth)
+	if width <= 0 {
+		return strings.TrimSpace(counts)
+	}
+	filled := width * percent / 100
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]" + counts
+}
+
+// spinner is the Spinner returned by NewSpinner
+type spinner struct {
+	shell       *Shell
+	mu          sync.Mutex
+	label       string
+	interactive bool
+	stop        chan struct{}
+	done        chan struct{}
+	once        sync.Once
+}
+
+// NewSpinner starts a spinner next to label, animated until Stop is called
+func (s *Shell) NewSpinner(label string) shellapi.Spinner {
+	sp := &spinner{
+		shell:       s,
+		label:       label,
+		interactive: s.stdoutIsTerminal(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if !sp.interactive {
+		fmt.Fprintln(s.Stdout(), label)
+		close(sp.done)
+		return sp
+	}
+	go sp.run()
+	return sp
+}
+
+// run draws a frame every spinner interval until stopped
+func (sp *spinner) run() {
+	defer close(sp.done)
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		sp.mu.Lock()
+		sp.shell.drawStatus(spinnerFrames[frame%len(spinnerFrames)] + " " + sp.label)
+		sp.mu.Unlock()
+		select {
+		case <-ticker.C:
+		case <-sp.stop:
+			return
+		}
+	}
+}
+
+// SetLabel changes the text shown next to the spinner
+func (sp *spinner) SetLabel(label string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if label == sp.label {
+		return
+	}
+	sp.label = label
+	if !sp.interactive {
+		fmt.Fprintln(sp.shell.Stdout(), label)
+	}
+}
+
+// Stop ends the animation and clears the spinner's line
+func (sp *spinner) Stop() {
+	sp.once.Do(func() {
+		close(sp.stop)
+		<-sp.done
+		sp.shell.finishStatus("", sp.interactive)
+	})
+}
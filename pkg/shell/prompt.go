@@ -0,0 +1,142 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chzyer/readline/runes"
+)
+
+// SetRightPrompt shows text at the right edge of the input line, for status
+// such as the elapsed time or current environment. It is hidden while the
+// line is too long to fit it, and an empty text removes it. It may be
+// called from any goroutine.
+func (s *Shell) SetRightPrompt(text string) {
+	s.promptMutex.Lock()
+	changed := s.rightPrompt != text
+	s.rightPrompt = text
+	s.promptMutex.Unlock()
+	if changed {
+		s.RequestRefresh()
+	}
+}
+
+// GetRightPrompt returns the right prompt text
+func (s *Shell) GetRightPrompt() string {
+	s.promptMutex.RLock()
+	defer s.promptMutex.RUnlock()
+	return s.rightPrompt
+}
+
+// fittingRightPrompt returns the right prompt if it fits beside the prompt
+// and line, leaving a column of space on either side
+func (s *Shell) fittingRightPrompt(line []rune) string {
+	right := s.GetRightPrompt()
+	if right == "" {
+		return ""
+	}
+	used := displayWidth(s.inputPrompt()) + runes.WidthAll(line)
+	if used+displayWidth(right)+2 > s.terminalWidth() {
+		return ""
+	}
+	return right
+}
+
+// paintRightPrompt appends right to a painted line, drawing it against the
+// right edge and moving the cursor back to the end of the line
+func (s *Shell) paintRightPrompt(painted, line []rune, right string) []rune {
+	if right == "" {
+		return painted
+	}
+	col := s.terminalWidth() - displayWidth(right) - 1
+	out := fmt.Sprintf("%s\r\033[%dC%s\r", string(painted), col, right)
+	if end := displayWidth(s.inputPrompt()) + runes.WidthAll(line); end > 0 {
+		out += fmt.Sprintf("\033[%dC", end)
+	}
+	return []rune(out)
+}
+
+// inputPrompt returns the last line of the prompt, the part readline draws
+// beside the input
+func (s *Shell) inputPrompt() string {
+	s.promptMutex.RLock()
+	defer s.promptMutex.RUnlock()
+	return s.currentPrompt[strings.LastIndex(s.currentPrompt, "\n")+1:]
+}
+
+// promptContext returns the lines of the prompt above the input line
+func (s *Shell) promptContext() string {
+	s.promptMutex.RLock()
+	defer s.promptMutex.RUnlock()
+	if i := strings.LastIndex(s.currentPrompt, "\n"); i >= 0 {
+		return s.currentPrompt[:i]
+	}
+	return ""
+}
+
+// showPromptContext prints the prompt lines above the input line, unless
+// they are still on screen, and remembers them so they can be redrawn
+func (s *Shell) showPromptContext(onScreen bool) {
+	context := s.promptContext()
+	if context != "" && !onScreen {
+		fmt.Fprintln(s.Stdout(), context)
+	}
+	s.promptMutex.Lock()
+	s.shownContext = context
+	s.promptMutex.Unlock()
+}
+
+// hidePromptContext forgets the shown context once a line is read, leaving
+// it in the scrollback
+func (s *Shell) hidePromptContext() {
+	s.promptMutex.Lock()
+	s.shownContext = ""
+	s.promptMutex.Unlock()
+}
+
+// promptContextChanged reports whether the context on screen is out of date
+func (s *Shell) promptContextChanged() bool {
+	context := s.promptContext()
+	s.promptMutex.RLock()
+	defer s.promptMutex.RUnlock()
+	return s.rl.Terminal.IsReading() && s.shownContext != context
+}
+
+// aboveInput returns what to write through readline to print text above
+// the input line. Readline only redraws the input line, so the prompt
+// context is erased and printed again below the text.
+func (s *Shell) aboveInput(text string) string {
+	context := s.promptContext()
+	s.promptMutex.Lock()
+	defer s.promptMutex.Unlock()
+	if !s.rl.Terminal.IsReading() || (s.shownContext == "" && context == "") {
+		return text
+	}
+
+	var b strings.Builder
+	if s.shownContext != "" {
+		fmt.Fprintf(&b, "\033[%dA\r\033[J", s.textRows(s.shownContext))
+	}
+	b.WriteString(text)
+	if context != "" {
+		b.WriteString(context + "\n")
+	}
+	s.shownContext = context
+	return b.String()
+}
+
+// textRows returns the terminal rows text takes, counting wrapped lines
+func (s *Shell) textRows(text string) int {
+	width := s.terminalWidth()
+	rows := 0
+	for _, line := range strings.Split(text, "\n") {
+		rows += max(1, (displayWidth(line)+width-1)/width)
+	}
+	return rows
+}
+
+// displayWidth returns the columns text takes on the terminal, ignoring
+// color sequences
+func displayWidth(text string) int {
+	return runes.WidthAll(runes.ColorFilter([]rune(text)))
+}
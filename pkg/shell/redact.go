@@ -0,0 +1,64 @@
+package shell
+
+import (
+	"regexp"
+	"strings"
+)
+
+// redactedText replaces secrets matched by the redaction registry
+const redactedText = "[REDACTED]"
+
+// RegisterRedaction adds a pattern whose matches are replaced before text is
+// printed by the shell or written to history. If the pattern has capture
+// groups only the groups are replaced, so `token=(\S+)` keeps the key name.
+func (s *Shell) RegisterRedaction(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	s.RegisterRedactor(func(text string) string {
+		return redactPattern(re, text)
+	})
+	return nil
+}
+
+// RegisterRedactor adds a function that rewrites text to hide secrets, for
+// modules that know their token formats better than a regex can describe
+func (s *Shell) RegisterRedactor(fn func(text string) string) {
+	s.redactMutex.Lock()
+	defer s.redactMutex.Unlock()
+	s.redactors = append(s.redactors, fn)
+}
+
+// Redact applies every registered redaction to text
+func (s *Shell) Redact(text string) string {
+	s.redactMutex.RLock()
+	defer s.redactMutex.RUnlock()
+	for _, fn := range s.redactors {
+		text = fn(text)
+	}
+	return text
+}
+
+// redactPattern replaces matches of re, or only its capture groups if it has any
+func redactPattern(re *regexp.Regexp, text string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllString(text, redactedText)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(text, -1) {
+		for g := 1; g <= re.NumSubexp(); g++ {
+			start, end := match[2*g], match[2*g+1]
+			if start < last || start < 0 {
+				continue
+			}
+			b.WriteString(text[last:start])
+			b.WriteString(redactedText)
+			last = end
+		}
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
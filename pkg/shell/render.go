@@ -0,0 +1,142 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renderInterval is how often queued redraws are flushed to the terminal
+const renderInterval = 33 * time.Millisecond
+
+// maxQueuedAlerts bounds the alerts waiting for the next tick, so a flood
+// from a runaway goroutine cannot grow the queue without limit. The oldest
+// are dropped first.
+const maxQueuedAlerts = 1000
+
+// renderer batches alerts and refresh requests so that bursts of updates
+// from spinners, prompt segments and background goroutines repaint the
+// input line once per tick instead of once per update
+type renderer struct {
+	mu      sync.Mutex
+	alerts  []alert
+	dropped int
+	refresh bool
+	stopped bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startRenderer starts flushing queued redraws on a ticker
+func (s *Shell) startRenderer() {
+	s.render.stop = make(chan struct{})
+	s.render.done = make(chan struct{})
+	go func() {
+		defer close(s.render.done)
+		ticker := time.NewTicker(renderInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flushRender()
+			case <-s.render.stop:
+				s.flushRender()
+				return
+			}
+		}
+	}()
+}
+
+// stopRenderer flushes anything still queued and stops the ticker
+func (s *Shell) stopRenderer() {
+	if s.render.stop == nil {
+		return
+	}
+	s.render.mu.Lock()
+	s.render.stopped = true
+	s.render.mu.Unlock()
+	close(s.render.stop)
+	<-s.render.done
+	s.render.stop = nil
+}
+
+// RequestRefresh asks for the input line to be repainted on the next tick.
+// Multiple requests within a tick result in a single redraw.
+func (s *Shell) RequestRefresh() {
+	s.render.mu.Lock()
+	defer s.render.mu.Unlock()
+	s.render.refresh = true
+}
+
+// alert is a message waiting to be printed above the input line
+type alert struct {
+	message  string
+	toStderr bool
+}
+
+// queueAlert adds a message to be printed above the input line, on stderr
+// if toStderr is set. It is safe to call from any goroutine. Once the
+// renderer has stopped the message is printed right away instead.
+func (s *Shell) queueAlert(message string, toStderr bool) {
+	s.render.mu.Lock()
+	stopped := s.render.stopped
+	if !stopped {
+		if len(s.render.alerts) >= maxQueuedAlerts {
+			s.render.alerts = s.render.alerts[1:]
+			s.render.dropped++
+		}
+		s.render.alerts = append(s.render.alerts, alert{message: message, toStderr: toStderr})
+	}
+	s.render.mu.Unlock()
+
+	if stopped {
+		out := s.Stdout()
+		if toStderr {
+			out = s.Stderr()
+		}
+		fmt.Fprintln(out, message)
+	}
+}
+
+// flushRender writes queued alerts and repaints the line if anything changed
+func (s *Shell) flushRender() {
+	s.render.mu.Lock()
+	alerts, refresh, dropped := s.render.alerts, s.render.refresh, s.render.dropped
+	s.render.alerts, s.render.refresh, s.render.dropped = nil, false, 0
+	s.render.mu.Unlock()
+
+	if dropped > 0 {
+		notice := alert{message: fmt.Sprintf("(%d earlier messages dropped)", dropped)}
+		alerts = append([]alert{notice}, alerts...)
+	}
+
+	if len(alerts) > 0 {
+		// Writing repaints the line, so no separate refresh is needed.
+		// Consecutive alerts for the same stream are written together.
+		for len(alerts) > 0 {
+			n := 1
+			for n < len(alerts) && alerts[n].toStderr == alerts[0].toStderr {
+				n++
+			}
+			messages := make([]string, n)
+			for i := range messages {
+				messages[i] = alerts[i].message
+			}
+			out := s.rl.Stdout()
+			if alerts[0].toStderr {
+				out = s.rl.Stderr()
+			}
+			out.Write([]byte(s.aboveInput(strings.Join(messages, "\n") + "\n")))
+			alerts = alerts[n:]
+		}
+		return
+	}
+	if refresh && s.promptContextChanged() {
+		s.rl.Write([]byte(s.aboveInput("")))
+		return
+	}
+	if refresh {
+		s.rl.Refresh()
+	}
+}
@@ -0,0 +1,63 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// ErrNotInteractive is returned by prompts that need a terminal, such as
+// Select, when commands are read from a pipe or file
+var ErrNotInteractive = errors.New("input is not a terminal")
+
+// Interactive reports whether commands are typed at a terminal, rather
+// than read from a pipe or file as in `myshell < commands.txt`. Input
+// given as any other reader with WithStdin is never a terminal.
+func (s *Shell) Interactive() bool {
+	f, ok := s.Stdin().(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && readline.IsTerminal(int(f.Fd()))
+}
+
+// runScript runs the commands read from stdin when it is not a terminal,
+// one per line with no prompt, banner or history, then exits with the
+// status of the last command. Blank lines and lines starting with # are
+// skipped.
+func (s *Shell) runScript() {
+	for {
+		line, err := s.readInputLine()
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			if line = strings.TrimSpace(s.runAfterReadline(line)); line != "" {
+				if err := s.runInterruptible(s.expandStatus(line)); err != nil {
+					s.printError(s.Stderr(), err)
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.Exit(s.LastStatus())
+}
+
+// readInputLine reads the next line of a non-terminal stdin, without its
+// line ending. Commands and the prompts they show, such as Confirm, share
+// the reader so each gets the lines meant for it.
+func (s *Shell) readInputLine() (string, error) {
+	if s.input == nil {
+		s.input = bufio.NewReader(s.Stdin())
+	}
+	line, err := s.input.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
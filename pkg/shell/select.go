@@ -0,0 +1,173 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// selectRows is the maximum number of options shown at once
+const selectRows = 10
+
+// picker renders a list below the prompt while Select or MultiSelect runs.
+// Without check boxes it picks the highlighted option.
+type picker struct {
+	shell    *Shell
+	prompt   string
+	options  []string
+	checked  []bool
+	selected int
+}
+
+// pickerKey handles a key while a picker is open. Only Enter and the keys
+// ending input reach readline, so nothing is typed on the line.
+func (p *picker) pickerKey(r rune) (rune, bool) {
+	switch r {
+	case readline.CharPrev:
+		p.selected = (p.selected - 1 + len(p.options)) % len(p.options)
+	case readline.CharNext:
+		p.selected = (p.selected + 1) % len(p.options)
+	case ' ':
+		if p.checked != nil {
+			p.checked[p.selected] = !p.checked[p.selected]
+		}
+	case 'a':
+		// Check everything, or clear everything when all are checked
+		all := true
+		for _, checked := range p.checked {
+			all = all && checked
+		}
+		for i := range p.checked {
+			p.checked[i] = !all
+		}
+	case readline.CharEnter, readline.CharCtrlJ, readline.CharInterrupt, readline.CharDelete:
+		return r, true
+	}
+	return r, false
+}
+
+// Paint draws the options, with their check boxes if any, below the prompt
+func (p *picker) Paint(line []rune, pos int) []rune {
+	if strings.HasSuffix(string(line), "\n") {
+		return p.shell.withStatus(line)
+	}
+	rows := make([]string, len(p.options))
+	for i, option := range p.options {
+		switch {
+		case p.checked == nil:
+			rows[i] = "  " + option
+		case p.checked[i]:
+			rows[i] = "  [x] " + option
+		default:
+			rows[i] = "  [ ] " + option
+		}
+	}
+	return p.shell.paintRows(p.prompt, line, rows, p.selected, selectRows)
+}
+
+// chosen returns the checked options in their original order
+func (p *picker) chosen() []string {
+	chosen := []string{}
+	for i, option := range p.options {
+		if p.checked[i] {
+			chosen = append(chosen, option)
+		}
+	}
+	return chosen
+}
+
+// Select shows options as a list and returns the one the user picks. The
+// arrows move and Enter accepts. Ctrl+C cancels with readline.ErrInterrupt.
+func (s *Shell) Select(label string, options []string) (string, error) {
+	if len(options) == 0 {
+		return "", errors.New("no options to select from")
+	}
+	p := &picker{
+		shell:   s,
+		prompt:  label + " (enter to accept): ",
+		options: options,
+	}
+	if err := s.pick(p); err != nil {
+		return "", err
+	}
+	chosen := options[p.selected]
+	fmt.Fprintf(s.Stdout(), "%s: %s\n", label, chosen)
+	return chosen, nil
+}
+
+// MultiSelect shows options as a checkbox list and returns those the user
+// checks. The arrows move, space toggles an option, a toggles them all and
+// Enter accepts. Ctrl+C cancels with readline.ErrInterrupt.
+func (s *Shell) MultiSelect(label string, options []string) ([]string, error) {
+	if len(options) == 0 {
+		return nil, errors.New("no options to select from")
+	}
+	p := &picker{
+		shell:   s,
+		prompt:  label + " (space to toggle, enter to accept): ",
+		options: options,
+		checked: make([]bool, len(options)),
+	}
+	if err := s.pick(p); err != nil {
+		return nil, err
+	}
+	chosen := p.chosen()
+	fmt.Fprintf(s.Stdout(), "%s: %s\n", label, strings.Join(chosen, ", "))
+	return chosen, nil
+}
+
+// pick swaps in a picker for one line, erasing it once submitted
+func (s *Shell) pick(p *picker) error {
+	if !s.Interactive() {
+		return ErrNotInteractive
+	}
+	cfg := s.rl.Config
+	prompt := s.inputPrompt()
+	autoComplete, painter := cfg.AutoComplete, cfg.Painter
+	cfg.AutoComplete, cfg.Painter, cfg.UniqueEditLine = nil, p, true
+	s.picker = p
+	s.rl.SetPrompt(p.prompt)
+	defer func() {
+		cfg.AutoComplete, cfg.Painter, cfg.UniqueEditLine = autoComplete, painter, false
+		s.rl.SetPrompt(prompt)
+		s.picker = nil
+	}()
+
+	_, err := s.rl.Readline()
+	return err
+}
+
+// ForEachSelected lets the user pick options with MultiSelect, then starts
+// a job running command once per chosen option, one after the other. A {}
+// in command is replaced by the quoted option, otherwise it is appended as
+// the last argument. Every option is tried, until the job is killed; the
+// job fails with the failures joined.
+func (s *Shell) ForEachSelected(label string, options []string, command string) error {
+	chosen, err := s.MultiSelect(label, options)
+	if err != nil || len(chosen) == 0 {
+		return err
+	}
+
+	s.startTask(fmt.Sprintf("%s (%d selected)", command, len(chosen)), func(ctx context.Context) error {
+		var errs []error
+		for _, option := range chosen {
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+			line := command + " " + shellapi.QuoteArg(option)
+			if strings.Contains(command, "{}") {
+				line = strings.ReplaceAll(command, "{}", shellapi.QuoteArg(option))
+			}
+			s.Info("%s", line)
+			if err := s.ExecuteCommandContext(ctx, line); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", option, err))
+			}
+		}
+		return errors.Join(errs...)
+	})
+	return nil
+}
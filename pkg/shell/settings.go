@@ -0,0 +1,195 @@
+package shell
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// setting is a named shell option that can be changed at runtime
+type setting struct {
+	description string
+	value       string
+	apply       func(value string) error
+}
+
+// RegisterSetting adds a runtime setting. The apply function validates and
+// applies new values; it is called once with the initial value.
+func (s *Shell) RegisterSetting(name, description, value string, apply func(value string) error) error {
+	if _, exists := s.GetSetting(name); exists {
+		return fmt.Errorf("setting already registered: %s", name)
+	}
+	if apply != nil {
+		if err := apply(value); err != nil {
+			return err
+		}
+	}
+
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	s.settings[name] = &setting{description: description, value: value, apply: apply}
+	return nil
+}
+
+// SetSetting changes the value of a registered setting
+func (s *Shell) SetSetting(name, value string) error {
+	s.settingsMutex.RLock()
+	st, ok := s.settings[name]
+	s.settingsMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown setting: %s", name)
+	}
+
+	// Apply outside the lock so the callback may use the shell
+	if st.apply != nil {
+		if err := st.apply(value); err != nil {
+			return err
+		}
+	}
+
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	st.value = value
+	return nil
+}
+
+// GetSetting returns the current value of a setting
+func (s *Shell) GetSetting(name string) (string, bool) {
+	s.settingsMutex.RLock()
+	defer s.settingsMutex.RUnlock()
+
+	st, ok := s.settings[name]
+	if !ok {
+		return "", false
+	}
+	return st.value, true
+}
+
+// GetSettings returns all registered settings sorted by name
+func (s *Shell) GetSettings() []shellapi.Setting {
+	s.settingsMutex.RLock()
+	defer s.settingsMutex.RUnlock()
+
+	settings := make([]shellapi.Setting, 0, len(s.settings))
+	for name, st := range s.settings {
+		settings = append(settings, shellapi.Setting{
+			Name:        name,
+			Description: st.description,
+			Value:       st.value,
+		})
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Name < settings[j].Name
+	})
+	return settings
+}
+
+// registerParserSettings exposes parser options as settings
+func (s *Shell) registerParserSettings() {
+	s.RegisterSetting("prefix-matching", "Resolve unambiguous command prefixes (on, off)", formatOnOff(s.prefixMatching.Load()),
+		func(value string) error {
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return err
+			}
+			s.prefixMatching.Store(enabled)
+			return nil
+		})
+}
+
+// registerCompletionSettings exposes completion options as settings
+func (s *Shell) registerCompletionSettings() {
+	s.RegisterSetting("fuzzy-completion", "Fall back to fuzzy matching in tab completion (on, off)", formatOnOff(s.fuzzyCompletion.Load()),
+		func(value string) error {
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return err
+			}
+			s.fuzzyCompletion.Store(enabled)
+			return nil
+		})
+	s.RegisterSetting("completion-case", "Letter case matching in tab completion (sensitive, insensitive, smart)", CompletionCase(s.completionCase.Load()).String(),
+		func(value string) error {
+			mode, err := ParseCompletionCase(value)
+			if err != nil {
+				return err
+			}
+			s.completionCase.Store(int32(mode))
+			return nil
+		})
+	s.RegisterSetting("completion-menu", "Cycle through completions in a menu with tab and the arrows (on, off)", formatOnOff(s.menuCompletion.Load()),
+		func(value string) error {
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return err
+			}
+			s.menuCompletion.Store(enabled)
+			return nil
+		})
+	s.RegisterSetting("autosuggest", "Suggest the latest matching history entry as you type (on, off)", formatOnOff(s.autosuggest.Load()),
+		func(value string) error {
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return err
+			}
+			s.autosuggest.Store(enabled)
+			return nil
+		})
+}
+
+// registerHistorySettings exposes the history ignore rules as settings
+func (s *Shell) registerHistorySettings() {
+	s.RegisterSetting("history-ignore-dups", "Leave a command out of the history when it repeats the previous one (on, off)", formatOnOff(s.historyIgnoreDups.Load()),
+		func(value string) error {
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return err
+			}
+			s.historyIgnoreDups.Store(enabled)
+			return nil
+		})
+	s.RegisterSetting("history-expansion", "Expand !! and !prefix to earlier commands (on, off)", formatOnOff(s.historyExpansion.Load()),
+		func(value string) error {
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return err
+			}
+			s.historyExpansion.Store(enabled)
+			return nil
+		})
+	s.RegisterSetting("history-ignore-space", "Leave commands typed with a leading space out of the history (on, off)", formatOnOff(s.historyIgnoreSpace.Load()),
+		func(value string) error {
+			enabled, err := parseOnOff(value)
+			if err != nil {
+				return err
+			}
+			s.historyIgnoreSpace.Store(enabled)
+			return nil
+		})
+}
+
+// parseOnOff accepts on/off in addition to the forms strconv.ParseBool knows
+func parseOnOff(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "yes":
+		return true, nil
+	case "off", "no":
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("expected on or off, got %q", value)
+	}
+	return enabled, nil
+}
+
+// formatOnOff renders a boolean setting value
+func formatOnOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -1,14 +1,27 @@
 package shell
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/Necromancerlabs/gocmd2/pkg/module"
 	"github.com/Necromancerlabs/gocmd2/pkg/module/core"
+	"github.com/Necromancerlabs/gocmd2/pkg/module/user"
 	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
 )
 
@@ -17,23 +30,245 @@ type Shell struct {
 	rootCmd        *cobra.Command
 	rl             *readline.Instance
 	currentPrompt  string
+	rightPrompt    string
+	shownContext   string
+	promptMutex    sync.RWMutex
 	commandModules []module.CommandModule
 	banner         string
+	bannerFunc     func() string
+	templateBanner bool
+	bannerTemplate *template.Template
+
+	// Streams used instead of os.Stdin, os.Stdout and os.Stderr when set
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	// Output being captured, innermost last, which Stdout and Stderr
+	// return while there is some
+	captureMutex sync.Mutex
+	outCaptures  []*capture
+	errCaptures  []*capture
 
 	// Track which modules are enabled
 	enabledModules map[string]bool
 	moduleCommands map[string][]*cobra.Command
 
-	// Shared state accessible to all modules
-	State      map[string]interface{}
-	stateMutex sync.RWMutex
+	// Modules whose commands are mounted under their name, and the commands
+	// they are mounted under
+	namespaced map[string]bool
+	namespaces map[string]*cobra.Command
+
+	// What RegisterModule does with clashing command names
+	conflictPolicy ConflictPolicy
+
+	// Core commands replaced by those of other modules, by module, put back
+	// while the module is disabled
+	replacedCore map[string][]coreReplacement
+
+	// Capabilities granted to each module, enforced on the ShellAPI handed
+	// to modules when capability checks are on
+	capabilityChecks bool
+	grants           map[string]map[shellapi.Capability]bool
+	moduleAPIs       map[string]shellapi.ShellAPI
+
+	// Named module groups, the one used as the active profile and the
+	// file remembering it between sessions
+	moduleGroups  map[string][]string
+	activeProfile string
+	profilePath   string
+
+	// Modules the user enabled or disabled, and the file remembering them
+	// between sessions, unset when WithModulePersistence turns it off
+	moduleChoices      map[string]bool
+	modulesPath        string
+	modulesPersistence bool
+	modulesRestored    bool
+	restoringModules   bool
+
+	// Flag values captured at registration, restored after every execution
+	flagDefaults map[*pflag.Flag]flagDefault
+
+	// History persistence; the shell writes the file itself unless it is
+	// plain text. An empty path keeps history in memory only.
+	historyPath     string
+	historyPathSet  bool
+	historyLimit    int
+	historyDisabled bool
+	historyKey      KeySource
+	historyKeyRaw   bool
+	historyCipher   *historyCipher
+
+	// Entries written to the history file, counted to know when to trim it
+	historyFileEntries int
+
+	// Rules for lines left out of the history
+	historyIgnoreDups  atomic.Bool
+	historyIgnoreSpace atomic.Bool
+	historyExclusions  []*regexp.Regexp
+	historyExpansion   atomic.Bool
+
+	// In-memory mode keeps new entries off disk. Readline is detached from
+	// a text history file the first time it is turned on, so the shell
+	// decides what is written.
+	historyInMemory atomic.Bool
+	historyDetached bool
+
+	// The shell's own copy of the history, used for suggestions. The last
+	// record is pending while its command runs.
+	historyRecords []historyEntry
+	historyPending bool
+	historyFormat  HistoryFormat
+	historyMutex   sync.RWMutex
+	autosuggest    atomic.Bool
+
+	// Runtime settings changed with the `set` command
+	settings      map[string]*setting
+	settingsMutex sync.RWMutex
+
+	// Filter applied to leveled output
+	output outputFilter
+
+	// Session overrides of the detected terminal width and color support
+	widthOverride atomic.Int32
+	colorMode     atomic.Int32
+
+	// Status bar pinned to the last row, drawn while statusHeight is set
+	status       string
+	statusHeight int
+	statusMutex  sync.Mutex
+
+	// Functions run by Exit before the process ends, last registered first
+	exitHandlers []func()
+
+	// Functions called when the terminal is resized
+	resizeHandlers []func(width, height int)
+	resizeMutex    sync.RWMutex
+
+	// Resolve unambiguous command prefixes
+	prefixMatching atomic.Bool
+
+	// Context commands run under, and that of the command running, which
+	// commands it executes derive theirs from
+	ctx     context.Context
+	execCtx atomic.Pointer[context.Context]
+
+	// One execution at a time uses the command tree; executing marks the
+	// one holding execMutex and running the commands it is running, whose
+	// flags the commands they execute leave alone
+	execMutex sync.Mutex
+	executing atomic.Pointer[execution]
+	running   []*cobra.Command
+
+	// How long commands may run unless annotated otherwise, 0 for no limit
+	commandTimeout atomic.Int64
+
+	// Exit status of the last command line, see LastStatus
+	lastStatus atomic.Int32
+
+	// Reader over stdin when it is not a terminal, see runScript
+	input *bufio.Reader
+
+	// Background jobs, and the streams handed out while they run
+	jobs      jobTable
+	jobStdout *jobWriter
+	jobStderr *jobWriter
+
+	// Cancels the command typed at the prompt while it runs, and whether
+	// Ctrl-C was already pressed for it
+	interrupt      context.CancelCauseFunc
+	interrupted    bool
+	interruptMutex sync.Mutex
+
+	// Transaction in progress, if any
+	txn      *transaction
+	txnMutex sync.Mutex
+
+	// Tab completion, with fuzzy matching as an optional fallback
+	completer       *treeCompleter
+	commandTree     *readline.PrefixCompleter
+	completerNodes  map[*cobra.Command][]readline.PrefixCompleterInterface
+	customCompleter Completer
+	fuzzyCompletion atomic.Bool
+	menuCompletion  atomic.Bool
+	completionCase  atomic.Int32
+	menu            *completionMenu
+	lastKey         rune
+	lastLine        string
+
+	// Completion functions registered by modules, keyed by command path
+	completers      map[string]func(prefix string) []string
+	argCompleters   map[string]map[int]func(prefix string) []string
+	completersMutex sync.RWMutex
+
+	// Functions hiding secrets in output and history
+	redactors   []func(text string) string
+	redactMutex sync.RWMutex
+
+	// Diagnostics run by the doctor command
+	healthChecks []healthCheck
+	healthMutex  sync.RWMutex
+
+	// Functions run at each stage of the REPL loop
+	hooks      loopHooks
+	hooksMutex sync.RWMutex
+
+	// Output of commands opted into result caching
+	cache      map[string]cacheEntry
+	cacheMutex sync.Mutex
+
+	// Module scratch directories under a per-session root
+	tempRoot  string
+	tempDirs  map[string]string
+	tempMutex sync.Mutex
+
+	// Batched redraws of the input line
+	render renderer
+
+	// Command palette opened with Ctrl+P
+	palette          *palette
+	paletteRequested bool
+
+	// Option list shown while Select or MultiSelect runs
+	picker *picker
+
+	// Output pager open after a paged command
+	pager *pager
+
+	// Set while Confirm and similar helpers read an answer, and while
+	// ReadSecret reads one that must not be shown
+	asking bool
+	secret bool
+
+	// Config file sections keyed by module name
+	configPath   string
+	moduleConfig map[string]map[string]interface{}
+
+	// Feature flags modules check before exposing experimental commands
+	features      map[string]*feature
+	featuresMutex sync.RWMutex
+
+	// State shared by the modules of this session. Keys set with a TTL
+	// have an expiry timer and may have functions called when they expire;
+	// watchers are called on every change. Protected keys map to the
+	// module owning them.
+	State          map[string]interface{}
+	stateExpiry    map[string]*stateExpiry
+	expireHandlers map[string][]func(key string, value interface{})
+	stateWatchers  map[string][]func(old, new interface{})
+	stateDecoders  map[string]func(data []byte) (interface{}, error)
+	stateModified  map[string]time.Time
+	stateOwners    map[string]string
+	stateMutex     sync.RWMutex
 }
 
 // Ensure Shell implements ShellAPI
 var _ shellapi.ShellAPI = (*Shell)(nil)
 
-// NewShell creates a new shell instance with core commands pre-registered
-func NewShell(rootCmdName, banner string) (*Shell, error) {
+// NewShell creates a new shell instance with core commands pre-registered.
+// With WithBannerTemplate the banner is a text/template, rendered with
+// BannerData when shown.
+func NewShell(rootCmdName, banner string, opts ...Option) (*Shell, error) {
 	// Use defaults if not provided
 	if rootCmdName == "" {
 		rootCmdName = "shell"
@@ -43,9 +278,63 @@ func NewShell(rootCmdName, banner string) (*Shell, error) {
 		currentPrompt:  "> ",
 		banner:         banner,
 		State:          make(map[string]interface{}),
+		stateExpiry:    make(map[string]*stateExpiry),
+		expireHandlers: make(map[string][]func(key string, value interface{})),
+		stateWatchers:  make(map[string][]func(old, new interface{})),
+		stateDecoders:  make(map[string]func(data []byte) (interface{}, error)),
+		stateModified:  make(map[string]time.Time),
+		stateOwners:    make(map[string]string),
+		replacedCore:   make(map[string][]coreReplacement),
 		enabledModules: make(map[string]bool),
 		moduleCommands: make(map[string][]*cobra.Command),
+		namespaced:     make(map[string]bool),
+		namespaces:     make(map[string]*cobra.Command),
+		moduleGroups:   make(map[string][]string),
+		grants:         make(map[string]map[shellapi.Capability]bool),
+		moduleAPIs:     make(map[string]shellapi.ShellAPI),
+		moduleChoices:  make(map[string]bool),
+		flagDefaults:   make(map[*pflag.Flag]flagDefault),
+		historyLimit:   defaultHistoryLimit,
+		settings:       make(map[string]*setting),
+		completers:     make(map[string]func(prefix string) []string),
+		argCompleters:  make(map[string]map[int]func(prefix string) []string),
+		commandTree:    readline.NewPrefixCompleter(),
+		completerNodes: make(map[*cobra.Command][]readline.PrefixCompleterInterface),
+		cache:          make(map[string]cacheEntry),
+		tempDirs:       make(map[string]string),
+		features:       make(map[string]*feature),
+		ctx:            context.Background(),
+	}
+	shell.jobs.jobs = make(map[int]*job)
+
+	// The last status is the shell's to set, so modules cannot write it
+	// and ClearState and RestoreState leave it alone
+	shell.storeState(shellapi.StateLastStatus, 0)
+	shell.stateOwners[shellapi.StateLastStatus] = "shell"
+	shell.jobStdout = &jobWriter{shell: shell, target: shell.outputStream}
+	shell.jobStderr = &jobWriter{shell: shell, target: shell.errorStream, toStderr: true}
+	shell.autosuggest.Store(true)
+	shell.modulesPersistence = true
+	shell.historyExpansion.Store(true)
+
+	for _, opt := range opts {
+		opt(shell)
 	}
+	shell.enableFeaturesFromEnv(rootCmdName)
+	if err := shell.parseBanner(); err != nil {
+		return nil, err
+	}
+	if err := shell.loadConfig(); err != nil {
+		return nil, err
+	}
+
+	// Settings start from the values chosen by options
+	shell.registerOutputSettings()
+	shell.registerDisplaySettings()
+	shell.registerParserSettings()
+	shell.registerCompletionSettings()
+	shell.registerHistorySettings()
+	shell.registerTimeoutSettings()
 
 	// Initialize the root command
 	shell.rootCmd = &cobra.Command{
@@ -57,60 +346,309 @@ func NewShell(rootCmdName, banner string) (*Shell, error) {
 		DisableFlagsInUseLine: true,
 	}
 	shell.rootCmd.CompletionOptions.DisableDefaultCmd = true
+	shell.rootCmd.SetOut(streamWriter(shell.Stdout))
+	shell.rootCmd.SetErr(streamWriter(shell.Stderr))
+	shell.rootCmd.SetFlagErrorFunc(flagError)
+
+	if !shell.historyPathSet {
+		shell.historyPath = dataFile(rootCmdName, "history")
+	}
+	if shell.historyDisabled {
+		shell.historyPath = ""
+	}
+	shell.profilePath = dataFile(rootCmdName, "module-profile")
+	if shell.modulesPersistence {
+		shell.modulesPath = dataFile(rootCmdName, "modules")
+	}
+
+	// Unless history is plain text, readline keeps it in memory only and
+	// the shell writes the file. Encrypted history has its own file so it
+	// never collides with plaintext history.
+	if shell.historyInMemory.Load() {
+		shell.historyDetached = true
+	}
+	historyFile := shell.historyPath
+	if shell.shellWritesHistory() {
+		historyFile = ""
+	}
+	if shell.historyKey != nil && shell.historyPath != "" {
+		shell.historyPath += ".enc"
+	}
+	prepareHistoryFile(shell.historyPath)
+
+	historyLimit := shell.historyLimit
+	if shell.historyDisabled {
+		historyLimit = -1
+	}
 
 	// Initialize readline
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:          shell.currentPrompt,
-		HistoryFile:     "/tmp/readline.tmp",
-		InterruptPrompt: "^C",
-		EOFPrompt:       "exit",
+		Prompt:                 shell.inputPrompt(),
+		HistoryFile:            historyFile,
+		HistoryLimit:           historyLimit,
+		DisableAutoSaveHistory: true,
+		InterruptPrompt:        "^C",
+		EOFPrompt:              "exit",
+		Stdin:                  readline.NewCancelableStdin(&paletteKeyReader{r: shell.Stdin()}),
+		Stdout:                 shell.stdout,
+		Stderr:                 shell.stderr,
+		FuncFilterInputRune:    shell.filterInput,
+		Listener:               readline.FuncListener(shell.onKey),
+		Painter:                painterFunc(shell.paint),
+		FuncOnWidthChanged:     shell.watchResize,
 	})
 	if err != nil {
 		return nil, err
 	}
 	shell.rl = rl
+	shell.installCompleter()
+	shell.startRenderer()
+
+	if shell.historyKey != nil {
+		if err := shell.loadEncryptedHistory(); err != nil {
+			shell.Close()
+			return nil, err
+		}
+	} else {
+		shell.loadHistory()
+	}
 
 	// Register the core module by default
 	coreModule := core.New()
-	shell.RegisterModule(coreModule)
+	if err := shell.RegisterModule(coreModule); err != nil {
+		shell.Close()
+		return nil, err
+	}
+
+	// User-defined functions are saved next to the history
+	if err := shell.RegisterModule(user.New(dataFile(rootCmdName, "functions"))); err != nil {
+		shell.Close()
+		return nil, err
+	}
+
+	// Exit ends the process without returning to the caller's deferred Close
+	shell.OnExit(func() {
+		shell.clearStatus()
+		shell.finishHistory(nil)
+		shell.removeTempDirs()
+	})
 
 	return shell, nil
 }
 
-// RegisterModule adds a new command module to the shell
-func (s *Shell) RegisterModule(module module.CommandModule) {
-	moduleName := module.Name()
+// RegisterModule adds a new command module to the shell. It fails for a nil
+// module, one without a name or named like a registered module. Commands
+// clashing with those of registered modules are handled by the conflict
+// policy; by default the module is not registered and the clashes are
+// returned. A module panicking while it is set up is removed again and the
+// panic returned as an error.
+func (s *Shell) RegisterModule(module module.CommandModule) error {
+	if module == nil || (reflect.ValueOf(module).Kind() == reflect.Pointer && reflect.ValueOf(module).IsNil()) {
+		return fmt.Errorf("cannot register a nil module")
+	}
+	var moduleName string
+	if err := callModule("module", "Name", func() { moduleName = module.Name() }); err != nil {
+		return err
+	}
+	if moduleName == "" {
+		return fmt.Errorf("cannot register a module without a name")
+	}
+	if _, ok := s.moduleCommands[moduleName]; ok {
+		return fmt.Errorf("module already registered: %s", moduleName)
+	}
+	api, err := s.moduleAPI(module)
+	if err != nil {
+		return err
+	}
 
-	// Add this module to our list
-	s.commandModules = append(s.commandModules, module)
+	// Hand the module its config first, so it can decide which commands
+	// and flags to build
+	if err := callModule(moduleName, "Configure", func() { s.configureModule(module) }); err != nil {
+		return err
+	}
 
-	// Store the commands for this module
-	commands := module.GetCommands()
+	// Store the commands for this module, under its name if namespaced
+	var commands []*cobra.Command
+	if err := callModule(moduleName, "GetCommands", func() { commands = module.GetCommands() }); err != nil {
+		return err
+	}
+	if err := applyAliases(module, commands); err != nil {
+		return err
+	}
+	if s.namespaced[moduleName] && moduleName != "core" {
+		commands = []*cobra.Command{s.namespaceCommand(module, commands)}
+	}
+	commands, err = s.resolveConflicts(moduleName, commands)
+	if err != nil {
+		delete(s.namespaces, moduleName)
+		return err
+	}
+
+	// Add this module to our list, after those of lower or equal priority
+	at := len(s.commandModules)
+	for at > 0 && modulePriority(s.commandModules[at-1]) > modulePriority(module) {
+		at--
+	}
+	s.commandModules = slices.Insert(s.commandModules, at, module)
 	s.moduleCommands[moduleName] = commands
+	s.moduleAPIs[moduleName] = api
 
 	// Enable this module by default
 	s.enabledModules[moduleName] = true
 
 	// Add the module's commands to the root command
 	for _, cmd := range commands {
-		s.rootCmd.AddCommand(cmd)
+		s.snapshotFlags(cmd)
+	}
+	s.rootCmd.AddCommand(commands...)
+
+	// Hand the module a reference to the shell
+	err = callModule(moduleName, "Initialize", func() { module.Initialize(api) })
+	if err == nil {
+		err = callModule(moduleName, "OnEnable", func() { s.notifyToggle(moduleName, true) })
+	}
+	if err != nil {
+		s.dropModule(moduleName)
+		return err
+	}
+
+	// Update command completion; modules may adjust their commands in
+	// Initialize, so the nodes are built afterwards
+	s.addCompleterNodes(s.moduleCommands[moduleName]...)
+	return nil
+}
+
+// RegisterModules registers several modules in priority order, so those
+// with a lower priority are initialized first.
+// Every module is tried; the failures are returned together.
+func (s *Shell) RegisterModules(modules ...module.CommandModule) error {
+	sorted := slices.Clone(modules)
+	slices.SortStableFunc(sorted, func(a, b module.CommandModule) int {
+		return modulePriority(a) - modulePriority(b)
+	})
+	var errs []error
+	for _, mod := range sorted {
+		if err := s.RegisterModule(mod); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// modulePriority returns the priority of a module, 0 if it has none
+func modulePriority(mod module.CommandModule) int {
+	if prioritizer, ok := mod.(module.Prioritizer); ok {
+		return prioritizer.Priority()
+	}
+	return 0
+}
+
+// callModule runs a method of a module, returning a panic as an error
+func callModule(moduleName, method string, fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("module %s panicked in %s: %v", moduleName, method, r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// dropModule undoes the registration of a module that failed to set up
+func (s *Shell) dropModule(moduleName string) {
+	s.restoreCoreCommands(moduleName, nil)
+	s.rootCmd.RemoveCommand(s.moduleCommands[moduleName]...)
+	s.removeCompleterNodes(s.moduleCommands[moduleName]...)
+	s.commandModules = slices.DeleteFunc(s.commandModules, func(m module.CommandModule) bool {
+		return m.Name() == moduleName
+	})
+	delete(s.moduleCommands, moduleName)
+	delete(s.enabledModules, moduleName)
+	delete(s.moduleAPIs, moduleName)
+	delete(s.namespaces, moduleName)
+	s.removeTempDir(moduleName)
+}
+
+// RegisterCommands adds commands to a registered module in one batch, so
+// modules generating many commands update the command tree and completion
+// once rather than per command
+func (s *Shell) RegisterCommands(moduleName string, cmds []*cobra.Command) error {
+	if _, ok := s.moduleCommands[moduleName]; !ok {
+		return fmt.Errorf("module not found: %s", moduleName)
+	}
+	if err := applyAliases(s.moduleNamed(moduleName), cmds); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		s.snapshotFlags(cmd)
+	}
+	if s.isNamespaced(moduleName) {
+		s.namespaces[moduleName].AddCommand(cmds...)
+		s.regroupCompletion(moduleName)
+		return nil
+	}
+	cmds, err := s.resolveConflicts(moduleName, cmds)
+	if err != nil {
+		return err
+	}
+	s.moduleCommands[moduleName] = append(s.moduleCommands[moduleName], cmds...)
+
+	// Commands of disabled modules are added when the module is enabled
+	if s.enabledModules[moduleName] {
+		s.rootCmd.AddCommand(cmds...)
+		s.addCompleterNodes(cmds...)
 	}
+	return nil
+}
 
-	// Initialize the module with a reference to the shell
-	module.Initialize(s)
+// UnregisterCommands removes commands previously added to a module
+func (s *Shell) UnregisterCommands(moduleName string, cmds []*cobra.Command) error {
+	commands, ok := s.moduleCommands[moduleName]
+	if !ok {
+		return fmt.Errorf("module not found: %s", moduleName)
+	}
+	if s.isNamespaced(moduleName) {
+		s.namespaces[moduleName].RemoveCommand(cmds...)
+		s.regroupCompletion(moduleName)
+		return nil
+	}
+	remaining := commands[:0]
+	for _, cmd := range commands {
+		if !slices.Contains(cmds, cmd) {
+			remaining = append(remaining, cmd)
+		}
+	}
+	s.moduleCommands[moduleName] = remaining
 
-	// Update command completion
-	s.updateCompleter()
+	if s.enabledModules[moduleName] {
+		s.rootCmd.RemoveCommand(cmds...)
+		s.removeCompleterNodes(cmds...)
+	}
+	if len(cmds) > 0 {
+		s.restoreCoreCommands(moduleName, cmds)
+	}
+	return nil
 }
 
-// SetPrompt changes the shell prompt
+// SetPrompt changes the shell prompt, redrawing only if it changed. A
+// prompt with newlines shows its last line beside the input and the lines
+// before it above.
 func (s *Shell) SetPrompt(prompt string) {
+	s.promptMutex.Lock()
+	if s.currentPrompt == prompt+" " {
+		s.promptMutex.Unlock()
+		return
+	}
 	s.currentPrompt = prompt + " "
-	s.rl.SetPrompt(s.currentPrompt)
+	s.promptMutex.Unlock()
+	s.rl.SetPrompt(s.inputPrompt())
+	s.RequestRefresh()
 }
 
 // GetPrompt returns the current prompt string
 func (s *Shell) GetPrompt() string {
+	s.promptMutex.RLock()
+	defer s.promptMutex.RUnlock()
 	return strings.TrimSpace(s.currentPrompt)
 }
 
@@ -119,101 +657,216 @@ func (s *Shell) GetReadline() *readline.Instance {
 	return s.rl
 }
 
-// SetState sets a value in the shared state
-func (s *Shell) SetState(key string, value interface{}) {
-	s.stateMutex.Lock()
-	defer s.stateMutex.Unlock()
-	s.State[key] = value
-}
-
-// GetState gets a value from the shared state
-func (s *Shell) GetState(key string) (interface{}, bool) {
-	s.stateMutex.RLock()
-	defer s.stateMutex.RUnlock()
-	val, ok := s.State[key]
-	return val, ok
-}
-
-// updateCompleter rebuilds the auto-completion based on available commands
-func (s *Shell) updateCompleter() {
-	completer := readline.NewPrefixCompleter()
-	for _, cmd := range s.rootCmd.Commands() {
-		completer.Children = append(completer.Children, readline.PcItem(cmd.Name()))
-	}
-	s.rl.Config.AutoComplete = completer
-}
-
+// PrintAlert prints a message above the input line. Alerts arriving close
+// together are written in one batch to avoid redrawing the prompt for each.
+//
+// Deprecated: use Info, Success, Warn or Error, which tag the message with
+// its level and are printed the same way while input is being read.
 func (s *Shell) PrintAlert(message string) {
-	s.rl.Write([]byte(message + "\n"))
-	s.rl.Refresh()
+	s.queueAlert(s.Redact(message), false)
 }
 
-// Run starts the shell's main loop
+// Run starts the shell's main loop. When stdin is not a terminal, it runs
+// the commands read from it instead and exits with the last one's status.
 func (s *Shell) Run() {
-	if s.banner != "" {
-		fmt.Println(s.banner)
-	} else {
-		fmt.Println("Interactive shell started. Type 'help' for available commands.")
+	s.restoreModuleState()
+	defer s.handleInterrupts()()
+	if !s.Interactive() {
+		s.runScript()
+		return
 	}
+	fmt.Fprintln(s.Stdout(), strings.TrimSuffix(s.renderBanner(), "\n"))
 
 	// Main REPL loop
+	pending := ""
 	for {
-		line, err := s.rl.Readline()
+		s.runBeforeReadline()
+		s.showPromptContext(pending != "")
+		line, err := s.rl.ReadlineWithDefault(pending)
+		s.hidePromptContext()
+		pending = ""
+		if errors.Is(err, readline.ErrInterrupt) {
+			// Ctrl-C at the prompt discards the line, as in other shells
+			continue
+		}
 		if err != nil {
 			break
 		}
 
+		if s.paletteRequested {
+			s.paletteRequested = false
+			s.rl.Config.UniqueEditLine = false
+			choice, run := s.runPalette(line)
+			if !run {
+				// Commands that take arguments are left on the input line
+				pending = choice
+				continue
+			}
+			fmt.Fprintln(s.Stdout(), s.inputPrompt()+choice)
+			line = choice
+		}
+
+		raw := line
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
+		expanded, changed, err := s.expandHistory(line)
+		if err != nil {
+			s.printError(s.Stdout(), err)
+			continue
+		}
+		if changed {
+			// Show what is about to run, as bash does
+			fmt.Fprintln(s.Stdout(), expanded)
+			line = expanded
+		}
+		if line = strings.TrimSpace(s.runAfterReadline(line)); line == "" {
+			continue
+		}
+		s.saveHistory(raw, line)
 
 		// Parse the line and execute the command using Cobra
-		args := strings.Split(line, " ")
-		s.rootCmd.SetArgs(args)
+		err = s.runInterruptible(s.expandStatus(line))
+		s.finishHistory(err)
+		if err != nil {
+			s.printError(s.Stdout(), err)
+		}
+	}
+}
+
+// ExecuteCommand runs a command programmatically. Executions take turns on
+// the command tree, so one started while another runs waits for it, unless
+// it comes from the command running. A command running as a job, or on a
+// goroutine of its own, uses ExecuteCommandContext with its cmd.Context()
+// instead.
+func (s *Shell) ExecuteCommand(command string) error {
+	return s.ExecuteCommandContext(s.commandContext(), command)
+}
 
-		err = s.rootCmd.Execute()
+// executeLine parses a command line and executes it, recording the status
+// it ends with as the last status. A failure is returned as an ExitError.
+// A line ending in & starts a job and leaves the last status alone, unless
+// the job cannot start; jobDone, if not nil, is called with the job's error
+// once it ends, or with the error keeping it from starting.
+func (s *Shell) executeLine(line string, jobDone func(error)) error {
+	if line, background := cutBackground(line); background {
+		err := s.startJob(line, jobDone)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
+			err = s.recordStatus(err)
+			if jobDone != nil {
+				jobDone(err)
+			}
 		}
+		return err
+	}
+	return s.recordStatus(s.dispatchLine(line))
+}
 
-		// Reset rootCmd for next command
-		s.rootCmd.SetArgs(nil)
+// dispatchLine executes a command line, paging the output when it ends in
+// `| more` or the command asks for it
+func (s *Shell) dispatchLine(line string) error {
+	line, more := cutMore(line)
+	args, err := s.parseLine(line)
+	if err != nil {
+		return err
 	}
+	cmd, _, err := s.rootCmd.Find(args)
+	if err != nil {
+		cmd = nil
+	}
+	run := func() error { return s.execute(args, false) }
+	if more || (cmd != nil && pagedCommand(cmd)) {
+		return s.executePaged(run)
+	}
+	return run()
 }
 
-// ExecuteCommand runs a command programmatically
-func (s *Shell) ExecuteCommand(command string) error {
-	args := strings.Split(command, " ")
-	s.rootCmd.SetArgs(args)
-	err := s.rootCmd.Execute()
+// execute runs the root command with the given arguments under a context
+// of its own, canceled when it returns or its timeout passes, and then
+// resets the command tree so the next execution starts from a clean state.
+// Starting a job, whose command the caller checked, runs no middleware,
+// which wraps the job's body instead.
+func (s *Shell) execute(args []string, job bool) error {
+	cmd, cmdArgs, err := s.rootCmd.Find(args)
+	if err != nil || cmd == s.rootCmd {
+		cmd = nil
+	}
+	if cmd != nil && !job {
+		if err := s.checkNotJob(cmd, nil); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := s.newCommandContext(cmd)
+	defer cancel()
+	defer s.withCommandContext(ctx, cmd)()
+
+	// Flags a job was using are reset once it has ended, and those of the
+	// commands running this one once they have
+	s.resetFlags()
+	if cmd != nil {
+		s.running = append(s.running, cmd)
+		defer func() { s.running = s.running[:len(s.running)-1] }()
+	}
+	run := func(rebuilt []string) error {
+		if rebuilt != nil {
+			s.rootCmd.SetArgs(rebuilt)
+		} else {
+			s.rootCmd.SetArgs(args)
+		}
+		return s.rootCmd.ExecuteContext(ctx)
+	}
+	if !job {
+		run = s.withCache(args, run)
+	}
+	if cmd != nil && !job {
+		err = s.withMiddleware(cmd, cmdArgs, run)(cmd, cmdArgs)
+	} else {
+		err = run(nil)
+	}
+
+	// Reset rootCmd for next command
 	s.rootCmd.SetArgs(nil)
-	return err
+	s.resetFlags()
+	return withExitStatus(ctx, cmd, timeoutError(ctx, err))
 }
 
-// OnExit registers handlers to be called when the shell exits
+// OnExit registers a handler run by Exit, and so by the exit command,
+// before the process ends. Handlers run last registered first.
 func (s *Shell) OnExit(fn func()) {
-	// Hook into the exit command
-	for _, cmd := range s.rootCmd.Commands() {
-		if cmd.Name() == "exit" {
-			originalRun := cmd.Run
-			cmd.Run = func(c *cobra.Command, args []string) {
-				fn()                 // Run the exit handler
-				originalRun(c, args) // Call the original exit function
-			}
-			break
-		}
+	s.exitHandlers = append(s.exitHandlers, fn)
+}
+
+// Exit runs the exit handlers and ends the process with the given code
+func (s *Shell) Exit(code int) {
+	for i := len(s.exitHandlers) - 1; i >= 0; i-- {
+		s.exitHandlers[i]()
 	}
+	os.Exit(code)
 }
 
 // SetHistoryFile changes the history file location
 func (s *Shell) SetHistoryFile(path string) error {
-	s.rl.SetHistoryPath(path)
+	s.historyPath = path
+	prepareHistoryFile(path)
+	if s.historyKey != nil {
+		s.rl.ResetHistory()
+		return s.loadEncryptedHistory()
+	}
+	if s.shellWritesHistory() {
+		s.rl.ResetHistory()
+	} else {
+		s.rl.SetHistoryPath(path)
+	}
+	s.loadHistory()
 	return nil
 }
 
 // Close cleans up the shell resources
 func (s *Shell) Close() {
+	s.stopRenderer()
+	s.clearStatus()
+	s.removeTempDirs()
 	s.rl.Close()
 }
 
@@ -239,13 +892,13 @@ func (s *Shell) EnableModule(moduleName string) error {
 	// Enable the module
 	s.enabledModules[moduleName] = true
 
-	// Add the module's commands to the root command
-	for _, cmd := range s.moduleCommands[moduleName] {
-		s.rootCmd.AddCommand(cmd)
-	}
-
-	// Update completer
-	s.updateCompleter()
+	// Add the module's commands to the root command and to completion, in
+	// place of the core commands they replace
+	s.swapCoreCommands(moduleName, true)
+	s.rootCmd.AddCommand(s.moduleCommands[moduleName]...)
+	s.addCompleterNodes(s.moduleCommands[moduleName]...)
+	s.saveModuleChoice(moduleName, true)
+	s.notifyToggle(moduleName, true)
 	return nil
 }
 
@@ -276,22 +929,39 @@ func (s *Shell) DisableModule(moduleName string) error {
 	// Disable the module
 	s.enabledModules[moduleName] = false
 
-	// Remove the module's commands from the root command
-	for _, cmd := range s.moduleCommands[moduleName] {
-		s.rootCmd.RemoveCommand(cmd)
-	}
-
-	// Update completer
-	s.updateCompleter()
+	// Remove the module's commands from the root command and from
+	// completion, putting back the core commands they replaced
+	s.rootCmd.RemoveCommand(s.moduleCommands[moduleName]...)
+	s.removeCompleterNodes(s.moduleCommands[moduleName]...)
+	s.swapCoreCommands(moduleName, false)
+	s.removeTempDir(moduleName)
+	s.saveModuleChoice(moduleName, false)
+	s.notifyToggle(moduleName, false)
 	return nil
 }
 
+// notifyToggle calls the OnEnable or OnDisable method of a module that has
+// one, after it was enabled or disabled
+func (s *Shell) notifyToggle(moduleName string, enabled bool) {
+	for _, mod := range s.commandModules {
+		if mod.Name() != moduleName {
+			continue
+		}
+		if enabler, ok := mod.(module.Enabler); ok && enabled {
+			enabler.OnEnable(s.moduleAPIs[moduleName])
+		}
+		if disabler, ok := mod.(module.Disabler); ok && !enabled {
+			disabler.OnDisable(s.moduleAPIs[moduleName])
+		}
+	}
+}
+
 // IsModuleEnabled returns whether a module is enabled
 func (s *Shell) IsModuleEnabled(moduleName string) bool {
 	return s.enabledModules[moduleName]
 }
 
-// GetModules returns a list of all module names
+// GetModules returns a list of all module names, in priority order
 func (s *Shell) GetModules() []string {
 	modules := make([]string, 0, len(s.commandModules))
 	for _, module := range s.commandModules {
@@ -300,17 +970,34 @@ func (s *Shell) GetModules() []string {
 	return modules
 }
 
-// GetEnabledModules returns a list of enabled module names
+// GetEnabledModules returns a list of enabled module names, in priority
+// order
 func (s *Shell) GetEnabledModules() []string {
 	enabled := []string{}
-	for name, isEnabled := range s.enabledModules {
-		if isEnabled {
-			enabled = append(enabled, name)
+	for _, module := range s.commandModules {
+		if s.enabledModules[module.Name()] {
+			enabled = append(enabled, module.Name())
 		}
 	}
 	return enabled
 }
 
+// GetModuleMetadata returns the metadata of a module, empty for modules
+// that do not implement module.Describer. It reports false if there is no
+// such module.
+func (s *Shell) GetModuleMetadata(moduleName string) (shellapi.ModuleMetadata, bool) {
+	for _, mod := range s.commandModules {
+		if mod.Name() != moduleName {
+			continue
+		}
+		if describer, ok := mod.(module.Describer); ok {
+			return describer.Metadata(), true
+		}
+		return shellapi.ModuleMetadata{}, true
+	}
+	return shellapi.ModuleMetadata{}, false
+}
+
 // GetRootCmd returns the shell's root command
 func (s *Shell) GetRootCmd() *cobra.Command {
 	return s.rootCmd
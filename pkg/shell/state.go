@@ -0,0 +1,568 @@
+package shell
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// stateExpiry is when a state key set with a TTL expires
+type stateExpiry struct {
+	expires time.Time
+	timer   *time.Timer
+}
+
+// expired reports whether the key has outlived its TTL. The timer removing
+// it may not have fired yet.
+func (e *stateExpiry) expired() bool {
+	return e != nil && !time.Now().Before(e.expires)
+}
+
+// SetState sets a value in the shared state, clearing any TTL the key had.
+// It fails with ErrStateProtected if a module protected the key.
+func (s *Shell) SetState(key string, value interface{}) error {
+	s.stateMutex.Lock()
+	if err := s.checkWritable("", key); err != nil {
+		s.stateMutex.Unlock()
+		return err
+	}
+	s.setState(key, value)
+	return nil
+}
+
+// setState sets a value, clearing its TTL, then releases stateMutex and
+// notifies the watchers. The caller holds stateMutex.
+func (s *Shell) setState(key string, value interface{}) {
+	old := s.liveState(key)
+	s.clearExpiry(key)
+	s.storeState(key, value)
+	watchers := s.watchers(key)
+	s.stateMutex.Unlock()
+	notifyWatchers(watchers, old, value)
+}
+
+// SetStateTTL sets a value in the shared state that is removed once ttl has
+// passed, for cached tokens and lookups. Setting the key again replaces the
+// TTL; SetState makes the value permanent.
+func (s *Shell) SetStateTTL(key string, value interface{}, ttl time.Duration) error {
+	s.stateMutex.Lock()
+	if err := s.checkWritable("", key); err != nil {
+		s.stateMutex.Unlock()
+		return err
+	}
+	old := s.liveState(key)
+	s.clearExpiry(key)
+	s.storeState(key, value)
+
+	expiry := &stateExpiry{expires: time.Now().Add(ttl)}
+	expiry.timer = time.AfterFunc(ttl, func() {
+		s.expireState(key, expiry)
+	})
+	s.stateExpiry[key] = expiry
+	watchers := s.watchers(key)
+	s.stateMutex.Unlock()
+	notifyWatchers(watchers, old, value)
+	return nil
+}
+
+// OnStateExpire registers a function called with the key and its last value
+// when the key expires, for instance to refresh a token
+func (s *Shell) OnStateExpire(key string, fn func(key string, value interface{})) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.expireHandlers[key] = append(s.expireHandlers[key], fn)
+}
+
+// UpdateState replaces the value of key with what fn returns for the
+// current one, nil if missing, while holding the state lock, so read and
+// write cannot race with other modules. fn must not call other state
+// methods. A TTL the key has is kept. fn is not called for a protected key.
+func (s *Shell) UpdateState(key string, fn func(old interface{}) interface{}) error {
+	s.stateMutex.Lock()
+	if err := s.checkWritable("", key); err != nil {
+		s.stateMutex.Unlock()
+		return err
+	}
+	old := s.liveState(key)
+	if s.stateExpiry[key].expired() {
+		s.clearExpiry(key)
+	}
+	value := fn(old)
+	s.storeState(key, value)
+	watchers := s.watchers(key)
+	s.stateMutex.Unlock()
+	notifyWatchers(watchers, old, value)
+	return nil
+}
+
+// CompareAndSwapState sets key to new only if it currently holds old, nil
+// meaning missing, and reports whether it did. Values of types that cannot
+// be compared with == never match. Like SetState, it clears any TTL.
+func (s *Shell) CompareAndSwapState(key string, old, new interface{}) (bool, error) {
+	s.stateMutex.Lock()
+	if err := s.checkWritable("", key); err != nil {
+		s.stateMutex.Unlock()
+		return false, err
+	}
+	current := s.liveState(key)
+	if !sameValue(current, old) {
+		s.stateMutex.Unlock()
+		return false, nil
+	}
+	s.clearExpiry(key)
+	s.storeState(key, new)
+	watchers := s.watchers(key)
+	s.stateMutex.Unlock()
+	notifyWatchers(watchers, current, new)
+	return true, nil
+}
+
+// WatchState registers a function called with the old and new value each
+// time key changes, so modules can react to changes made by others instead
+// of polling. A missing value is nil; it is called after the change, from
+// the goroutine making it.
+func (s *Shell) WatchState(key string, fn func(old, new interface{})) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.stateWatchers[key] = append(s.stateWatchers[key], fn)
+}
+
+// GetState gets a value from the shared state
+func (s *Shell) GetState(key string) (interface{}, bool) {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	if s.stateExpiry[key].expired() {
+		return nil, false
+	}
+	val, ok := s.State[key]
+	return val, ok
+}
+
+// StateKeys returns the sorted shared state keys starting with prefix. Its
+// signature fits RegisterCompleter, so it can complete any argument.
+func (s *Shell) StateKeys(prefix string) []string {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	keys := []string{}
+	for key := range s.State {
+		if strings.HasPrefix(key, prefix) && !s.stateExpiry[key].expired() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DeleteState removes a key from the shared state, reporting whether it
+// was there. A protected key must be unprotected by its owner first.
+func (s *Shell) DeleteState(key string) (bool, error) {
+	s.stateMutex.Lock()
+	if err := s.checkWritable("", key); err != nil {
+		s.stateMutex.Unlock()
+		return false, err
+	}
+	old, ok := s.State[key]
+	ok = ok && !s.stateExpiry[key].expired()
+	s.clearExpiry(key)
+	s.removeState(key)
+	watchers := s.watchers(key)
+	s.stateMutex.Unlock()
+
+	if ok {
+		notifyWatchers(watchers, old, nil)
+	}
+	return ok, nil
+}
+
+// ListStateKeys returns every shared state key, sorted
+func (s *Shell) ListStateKeys() []string {
+	return s.StateKeys("")
+}
+
+// ClearState removes every key from the shared state except protected
+// ones. Watchers stay registered and are told about each removed key.
+func (s *Shell) ClearState() {
+	s.stateMutex.Lock()
+	removed := make(map[string]interface{})
+	for key, value := range s.State {
+		if _, ok := s.stateOwners[key]; ok {
+			continue
+		}
+		if !s.stateExpiry[key].expired() {
+			removed[key] = value
+		}
+		s.clearExpiry(key)
+		s.removeState(key)
+	}
+	notify := make(map[string][]func(old, new interface{}))
+	for key := range removed {
+		notify[key] = s.watchers(key)
+	}
+	s.stateMutex.Unlock()
+
+	for key, value := range removed {
+		notifyWatchers(notify[key], value, nil)
+	}
+}
+
+// SnapshotState returns a deep copy of the shared state, for commands that
+// need to roll back a risky operation with RestoreState. Maps, slices and
+// exported struct fields are copied; pointers, channels, functions and
+// unexported fields are kept as they are, so handles stay the live ones.
+func (s *Shell) SnapshotState() map[string]interface{} {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	snapshot := make(map[string]interface{}, len(s.State))
+	for key, value := range s.State {
+		if !s.stateExpiry[key].expired() {
+			snapshot[key] = deepCopy(value)
+		}
+	}
+	return snapshot
+}
+
+// RestoreState replaces the shared state with a copy of snapshot. TTLs are
+// cleared, and watchers are called for every key that was or is now set.
+// Protected keys keep their current value.
+func (s *Shell) RestoreState(snapshot map[string]interface{}) {
+	s.stateMutex.Lock()
+	old := make(map[string]interface{}, len(s.State))
+	for key, value := range s.State {
+		if _, ok := s.stateOwners[key]; ok {
+			continue
+		}
+		if !s.stateExpiry[key].expired() {
+			old[key] = value
+		}
+		s.clearExpiry(key)
+		s.removeState(key)
+	}
+	current := make(map[string]interface{}, len(snapshot))
+	for key, value := range snapshot {
+		if _, ok := s.stateOwners[key]; !ok {
+			current[key] = deepCopy(value)
+			s.storeState(key, current[key])
+		}
+	}
+	notify := make(map[string][]func(old, new interface{}))
+	for key := range old {
+		notify[key] = s.watchers(key)
+	}
+	for key := range current {
+		notify[key] = s.watchers(key)
+	}
+	s.stateMutex.Unlock()
+
+	for key, watchers := range notify {
+		notifyWatchers(watchers, old[key], current[key])
+	}
+}
+
+// StateStats reports how much the session state holds: the number of
+// keys, their approximate size in bytes and when each was last changed,
+// largest first, to spot modules leaking memory into the state
+func (s *Shell) StateStats() shellapi.StateStats {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	stats := shellapi.StateStats{}
+	for key, value := range s.State {
+		if s.stateExpiry[key].expired() {
+			continue
+		}
+		size := len(key) + approximateSize(reflect.ValueOf(value), make(map[uintptr]bool))
+		stats.Keys++
+		stats.Bytes += size
+		stats.Entries = append(stats.Entries, shellapi.StateKeyStats{
+			Key:      key,
+			Bytes:    size,
+			Modified: s.stateModified[key],
+		})
+	}
+	sort.Slice(stats.Entries, func(i, j int) bool {
+		a, b := stats.Entries[i], stats.Entries[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Key < b.Key
+	})
+	return stats
+}
+
+// ProtectState makes key read-only to every module but owner, for keys
+// such as credentials or connection handles that others must not replace.
+// It fails if another module already protects the key.
+func (s *Shell) ProtectState(owner, key string) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if err := s.checkWritable(owner, key); err != nil {
+		return err
+	}
+	s.stateOwners[key] = owner
+	return nil
+}
+
+// UnprotectState lets every module write key again. Only the owner can
+// unprotect it.
+func (s *Shell) UnprotectState(owner, key string) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if err := s.checkWritable(owner, key); err != nil {
+		return err
+	}
+	delete(s.stateOwners, key)
+	return nil
+}
+
+// SetOwnedState sets a key protected by owner, as SetState does for
+// unprotected keys. It fails if another module protects the key.
+func (s *Shell) SetOwnedState(owner, key string, value interface{}) error {
+	s.stateMutex.Lock()
+	if err := s.checkWritable(owner, key); err != nil {
+		s.stateMutex.Unlock()
+		return err
+	}
+	s.setState(key, value)
+	return nil
+}
+
+// StateOwner returns the module protecting key, if any
+func (s *Shell) StateOwner(key string) (string, bool) {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	owner, ok := s.stateOwners[key]
+	return owner, ok
+}
+
+// checkWritable fails if a module other than writer protects key; an
+// empty writer is any module. The caller holds stateMutex.
+func (s *Shell) checkWritable(writer, key string) error {
+	if owner, ok := s.stateOwners[key]; ok && owner != writer {
+		return fmt.Errorf("%w: %s is owned by %s", shellapi.ErrStateProtected, key, owner)
+	}
+	return nil
+}
+
+// storeState sets a key and records when it changed. The caller holds
+// stateMutex.
+func (s *Shell) storeState(key string, value interface{}) {
+	s.State[key] = value
+	s.stateModified[key] = time.Now()
+}
+
+// removeState deletes a key. The caller holds stateMutex.
+func (s *Shell) removeState(key string) {
+	delete(s.State, key)
+	delete(s.stateModified, key)
+}
+
+// liveState returns the value of a key, or nil if it is missing or has
+// expired. The caller holds stateMutex.
+func (s *Shell) liveState(key string) interface{} {
+	if s.stateExpiry[key].expired() {
+		return nil
+	}
+	return s.State[key]
+}
+
+// watchers returns a copy of the watchers of a key, to be called once
+// stateMutex is released. The caller holds stateMutex.
+func (s *Shell) watchers(key string) []func(old, new interface{}) {
+	return append([]func(old, new interface{}){}, s.stateWatchers[key]...)
+}
+
+// notifyWatchers calls each watcher with the old and new value
+func notifyWatchers(watchers []func(old, new interface{}), old, new interface{}) {
+	for _, fn := range watchers {
+		fn(old, new)
+	}
+}
+
+// sameValue reports whether a and b are equal, without panicking on types
+// == cannot compare
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// clearExpiry stops the TTL of a key. The caller holds stateMutex.
+func (s *Shell) clearExpiry(key string) {
+	if expiry, ok := s.stateExpiry[key]; ok {
+		expiry.timer.Stop()
+		delete(s.stateExpiry, key)
+	}
+}
+
+// expireState removes a key whose TTL has passed and runs its expiry
+// functions, unless the key was set again since
+func (s *Shell) expireState(key string, expiry *stateExpiry) {
+	s.stateMutex.Lock()
+	if s.stateExpiry[key] != expiry {
+		s.stateMutex.Unlock()
+		return
+	}
+	value := s.State[key]
+	s.removeState(key)
+	delete(s.stateExpiry, key)
+	handlers := append([]func(string, interface{}){}, s.expireHandlers[key]...)
+	watchers := s.watchers(key)
+	s.stateMutex.Unlock()
+
+	for _, fn := range handlers {
+		fn(key, value)
+	}
+	notifyWatchers(watchers, value, nil)
+}
+
+// deepCopy returns a copy of v sharing no maps or slices with it. Pointers,
+// channels and functions are kept as they are, so handles such as
+// connections stay the live ones.
+func deepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(v), make(map[copiedKey]reflect.Value)).Interface()
+}
+
+// copiedKey identifies a map or slice already copied
+type copiedKey struct {
+	ptr    uintptr
+	typ    reflect.Type
+	length int
+}
+
+// copyValue copies the maps, slices, arrays and exported struct fields in
+// v recursively. seen maps those already copied to their copies, so shared
+// and cyclic ones keep their shape.
+func copyValue(v reflect.Value, seen map[copiedKey]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(copyValue(v.Elem(), seen))
+		return copied
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := copiedKey{v.Pointer(), v.Type(), 0}
+		if copied, ok := seen[key]; ok {
+			return copied
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		seen[key] = copied
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(copyValue(iter.Key(), seen), copyValue(iter.Value(), seen))
+		}
+		return copied
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		key := copiedKey{v.Pointer(), v.Type(), v.Len()}
+		if copied, ok := seen[key]; ok {
+			return copied
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		seen[key] = copied
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(copyValue(v.Index(i), seen))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(copyValue(v.Index(i), seen))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				copied.Field(i).Set(copyValue(v.Field(i), seen))
+			}
+		}
+		return copied
+	}
+	return v
+}
+
+// approximateSize estimates the memory held by v: its own size plus what
+// its pointers, strings, slices, maps and exported struct fields refer to,
+// counting each pointer, slice and map once. Values implementing
+// StateMarshaler are sized by what they marshal to, and unexported fields
+// only by their own size, so live handles are not walked without their
+// locks.
+func approximateSize(v reflect.Value, seen map[uintptr]bool) int {
+	if !v.IsValid() {
+		return 0
+	}
+	size := int(v.Type().Size())
+	if v.CanInterface() && v.Kind() != reflect.Interface {
+		if marshaler, ok := v.Interface().(shellapi.StateMarshaler); ok {
+			if v.Kind() == reflect.Pointer && v.IsNil() {
+				return size
+			}
+			if data, err := marshaler.MarshalState(); err == nil {
+				return size + len(data)
+			}
+			return size
+		}
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return size
+		}
+		seen[v.Pointer()] = true
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		return size + approximateSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return size
+		}
+		return size + approximateSize(v.Elem(), seen)
+	case reflect.String:
+		return size + v.Len()
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			size += approximateSize(v.Index(i), seen)
+		}
+		return size + (v.Cap()-v.Len())*int(v.Type().Elem().Size())
+	case reflect.Array:
+		size = 0
+		for i := 0; i < v.Len(); i++ {
+			size += approximateSize(v.Index(i), seen)
+		}
+		return size
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			size += approximateSize(iter.Key(), seen) + approximateSize(iter.Value(), seen)
+		}
+		return size
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				field := v.Field(i)
+				size += approximateSize(field, seen) - int(field.Type().Size())
+			}
+		}
+		return size
+	}
+	return size
+}
@@ -0,0 +1,102 @@
+package shell
+
+import "fmt"
+
+// SetStatus pins text to the bottom row of the terminal, below the prompt
+// and command output, for state such as the environment or running jobs.
+// Output scrolls above it, so the scrollback and the input line are left
+// alone. An empty text removes the bar. It may be called from any
+// goroutine.
+func (s *Shell) SetStatus(text string) {
+	s.statusMutex.Lock()
+	if text == s.status || !s.stdoutIsTerminal() {
+		s.statusMutex.Unlock()
+		return
+	}
+	s.status = text
+	var seq string
+	if text == "" {
+		seq = s.releaseStatusRow()
+	} else {
+		seq = s.reserveStatusRow() + s.statusSequence()
+	}
+	s.statusMutex.Unlock()
+
+	// Writing while input is read repaints the line, taking the lock again
+	s.rl.Write([]byte(seq))
+}
+
+// GetStatus returns the status bar text
+func (s *Shell) GetStatus() string {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	return s.status
+}
+
+// reserveStatusRow limits scrolling to the rows above the last one, first
+// scrolling the screen if the cursor is on the last row. The scroll region
+// moves the cursor, so it is saved around the change.
+func (s *Shell) reserveStatusRow() string {
+	height := s.Height()
+	if s.statusHeight == height || height < 2 {
+		return ""
+	}
+	s.statusHeight = height
+	return fmt.Sprintf("\n\033[A\0337\033[1;%dr\0338", height-1)
+}
+
+// releaseStatusRow restores the full scroll region and clears the bar
+func (s *Shell) releaseStatusRow() string {
+	if s.statusHeight == 0 {
+		return ""
+	}
+	height := s.statusHeight
+	s.statusHeight = 0
+	return fmt.Sprintf("\0337\033[r\033[%d;1H\033[2K\0338", height)
+}
+
+// statusSequence draws the bar on the last row and returns the cursor to
+// where it was
+func (s *Shell) statusSequence() string {
+	if s.status == "" || s.statusHeight == 0 {
+		return ""
+	}
+	text := truncate(s.status, s.terminalWidth()-1)
+	if s.ColorEnabled() {
+		text = "\033[7m" + text + "\033[0m"
+	}
+	return fmt.Sprintf("\0337\033[%d;1H%s\033[K\0338", s.statusHeight, text)
+}
+
+// withStatus appends the bar to a painted line. Readline clears to the end
+// of the screen before painting, which erases the bar each time.
+func (s *Shell) withStatus(painted []rune) []rune {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	if seq := s.statusSequence(); seq != "" {
+		return append(painted, []rune(seq)...)
+	}
+	return painted
+}
+
+// resizeStatus moves the bar to the new last row after a resize
+func (s *Shell) resizeStatus() {
+	s.statusMutex.Lock()
+	if s.statusHeight == 0 {
+		s.statusMutex.Unlock()
+		return
+	}
+	seq := s.reserveStatusRow() + s.statusSequence()
+	s.statusMutex.Unlock()
+	s.rl.Write([]byte(seq))
+}
+
+// clearStatus removes the bar, restoring the terminal's scroll region
+// before the shell exits
+func (s *Shell) clearStatus() {
+	s.statusMutex.Lock()
+	s.status = ""
+	seq := s.releaseStatusRow()
+	s.statusMutex.Unlock()
+	s.rl.Write([]byte(seq))
+}
@@ -0,0 +1,83 @@
+package shell
+
+import (
+	"io"
+	"os"
+
+	"github.com/chzyer/readline"
+)
+
+// Stdin returns the stream the shell reads input from
+func (s *Shell) Stdin() io.Reader {
+	if s.stdin != nil {
+		return s.stdin
+	}
+	return os.Stdin
+}
+
+// Stdout returns the writer the shell and its commands print output to.
+// While output is captured, it collects what commands print. While jobs
+// run, what they print at the prompt is shown above it.
+func (s *Shell) Stdout() io.Writer {
+	if w := s.capturing(&s.outCaptures); w != nil {
+		return w
+	}
+	if s.hasJobs() {
+		return s.jobStdout
+	}
+	return s.outputStream()
+}
+
+// Stderr returns the writer the shell and its commands print errors to
+func (s *Shell) Stderr() io.Writer {
+	if w := s.capturing(&s.errCaptures); w != nil {
+		return w
+	}
+	if s.hasJobs() {
+		return s.jobStderr
+	}
+	return s.errorStream()
+}
+
+// outputStream returns the stream output is written to
+func (s *Shell) outputStream() io.Writer {
+	if s.stdout != nil {
+		return s.stdout
+	}
+	return os.Stdout
+}
+
+// errorStream returns the stream errors are written to
+func (s *Shell) errorStream() io.Writer {
+	if s.stderr != nil {
+		return s.stderr
+	}
+	return os.Stderr
+}
+
+// stdoutIsTerminal reports whether output is shown on a terminal, where
+// progress and status can be drawn in place
+func (s *Shell) stdoutIsTerminal() bool {
+	if s.capturing(&s.outCaptures) != nil {
+		return false
+	}
+	_, ok := terminalFd(s.outputStream())
+	return ok
+}
+
+// terminalFd returns the file descriptor of w if it is a terminal
+func terminalFd(w io.Writer) (int, bool) {
+	f, ok := w.(*os.File)
+	if !ok || !readline.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+	return int(f.Fd()), true
+}
+
+// streamWriter writes to whatever stream it returns at the time of the
+// write, so writers handed out once follow later redirects
+type streamWriter func() io.Writer
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	return w().Write(p)
+}
@@ -0,0 +1,89 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// painterFunc adapts a function to readline's Painter interface
+type painterFunc func(line []rune, pos int) []rune
+
+func (f painterFunc) Paint(line []rune, pos int) []rune {
+	return f(line, pos)
+}
+
+// suggest returns the rest of the most recent history entry starting with line
+func (s *Shell) suggest(line string) string {
+	if line == "" || !s.autosuggest.Load() {
+		return ""
+	}
+	s.historyMutex.RLock()
+	defer s.historyMutex.RUnlock()
+	for i := len(s.historyRecords) - 1; i >= 0; i-- {
+		entry := s.historyRecords[i].Line
+		if len(entry) > len(line) && strings.HasPrefix(entry, line) {
+			return entry[len(line):]
+		}
+	}
+	return ""
+}
+
+// paint renders the input line: the completion menu when open, otherwise
+// the history suggestion and the right prompt. Submitted lines are left
+// plain, so the right prompt isn't kept in the scrollback.
+func (s *Shell) paint(line []rune, pos int) []rune {
+	if strings.ContainsRune(string(line), '\n') {
+		return s.withStatus(line)
+	}
+	if s.menu != nil {
+		return s.menu.Paint(s, s.inputPrompt(), line)
+	}
+	right := s.fittingRightPrompt(line)
+	reserve := 0
+	if right != "" {
+		reserve = displayWidth(right) + 1
+	}
+	return s.withStatus(s.paintRightPrompt(s.paintSuggestion(line, pos, reserve), line, right))
+}
+
+// paintSuggestion draws the history suggestion in grey after the cursor
+// when it sits at the end of the line, keeping clear of the last reserve
+// columns
+func (s *Shell) paintSuggestion(line []rune, pos int, reserve int) []rune {
+	if pos != len(line) || strings.ContainsRune(string(line), '\n') {
+		return line
+	}
+	// Without color the suggestion can't be told apart from typed text
+	if !s.ColorEnabled() {
+		return line
+	}
+	suggestion := []rune(s.suggest(string(line)))
+	if len(suggestion) == 0 {
+		return line
+	}
+
+	// Keep the suggestion on the current terminal row
+	room := s.terminalWidth() - displayWidth(s.inputPrompt()) - len(line) - 1 - reserve
+	if room <= 0 {
+		return line
+	}
+	if len(suggestion) > room {
+		suggestion = suggestion[:room]
+	}
+	painted := fmt.Sprintf("%s\033[90m%s\033[0m\033[%dD", string(line), string(suggestion), len(suggestion))
+	return []rune(painted)
+}
+
+// acceptSuggestion completes the line with the suggestion when the right
+// arrow is pressed at the end of the line
+func (s *Shell) acceptSuggestion(line []rune, pos int) ([]rune, int, bool) {
+	if pos != len(line) || s.palette != nil {
+		return nil, 0, false
+	}
+	suggestion := s.suggest(string(line))
+	if suggestion == "" {
+		return nil, 0, false
+	}
+	newLine := []rune(string(line) + suggestion)
+	return newLine, len(newLine), true
+}
@@ -0,0 +1,73 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// minColumnWidth is the narrowest a column is truncated to
+const minColumnWidth = 4
+
+// Table prints rows under a header line, sizing each column to its widest
+// cell. Columns are truncated, widest first, to fit the output width, and
+// headers are bold when colors are enabled. Rows may be shorter than the
+// header.
+func (s *Shell) Table(headers []string, rows [][]string) {
+	widths := make([]int, len(headers))
+	for i, header := range headers {
+		widths[i] = displayWidth(header)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], displayWidth(cell))
+			}
+		}
+	}
+
+	// Each line is indented two columns and cells are two columns apart
+	available := s.Width() - 1 - 2*len(widths)
+	for sum(widths) > available {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			break
+		}
+		widths[widest]--
+	}
+
+	header := tableLine(headers, widths)
+	if s.ColorEnabled() && strings.TrimSpace(header) != "" {
+		header = "\033[1m" + header + "\033[0m"
+	}
+	fmt.Fprintln(s.Stdout(), header)
+	for _, row := range rows {
+		fmt.Fprintln(s.Stdout(), s.Redact(tableLine(row, widths)))
+	}
+}
+
+// tableLine formats cells to the column widths
+func tableLine(cells []string, widths []int) string {
+	var b strings.Builder
+	for i, width := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = truncate(cells[i], width)
+		}
+		b.WriteString("  " + cell + strings.Repeat(" ", width-displayWidth(cell)))
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// sum adds up column widths
+func sum(widths []int) int {
+	total := 0
+	for _, width := range widths {
+		total += width
+	}
+	return total
+}
@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// TempDir returns a scratch directory for a module, created on first use.
+// It is removed with its contents when the module is disabled or the shell
+// exits, and directories left behind by a crashed shell are removed the next
+// time one starts.
+func (s *Shell) TempDir(module string) (string, error) {
+	s.tempMutex.Lock()
+	defer s.tempMutex.Unlock()
+
+	if dir, ok := s.tempDirs[module]; ok {
+		return dir, nil
+	}
+	if s.tempRoot == "" {
+		sweepTempRoots(s.rootCmd.Name())
+		root, err := os.MkdirTemp("", fmt.Sprintf("%s-%d-", s.rootCmd.Name(), os.Getpid()))
+		if err != nil {
+			return "", err
+		}
+		s.tempRoot = root
+	}
+	dir := filepath.Join(s.tempRoot, module)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	s.tempDirs[module] = dir
+	return dir, nil
+}
+
+// removeTempDir deletes the scratch directory of a module, if it has one
+func (s *Shell) removeTempDir(module string) {
+	s.tempMutex.Lock()
+	defer s.tempMutex.Unlock()
+	if dir, ok := s.tempDirs[module]; ok {
+		os.RemoveAll(dir)
+		delete(s.tempDirs, module)
+	}
+}
+
+// removeTempDirs deletes every scratch directory
+func (s *Shell) removeTempDirs() {
+	s.tempMutex.Lock()
+	defer s.tempMutex.Unlock()
+	if s.tempRoot != "" {
+		os.RemoveAll(s.tempRoot)
+		s.tempRoot = ""
+		s.tempDirs = make(map[string]string)
+	}
+}
+
+// sweepTempRoots removes the scratch directories of shells named app whose
+// process no longer exists
+func sweepTempRoots(app string) {
+	matches, _ := filepath.Glob(filepath.Join(os.TempDir(), app+"-*"))
+	for _, root := range matches {
+		rest := strings.TrimPrefix(filepath.Base(root), app+"-")
+		pidText, _, ok := strings.Cut(rest, "-")
+		if !ok {
+			continue
+		}
+		pid, err := strconv.Atoi(pidText)
+		if err != nil || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			os.RemoveAll(root)
+		}
+	}
+}
+
+// processAlive reports whether a process with the given pid is running,
+// erring on the side of yes when it cannot tell
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
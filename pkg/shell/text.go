@@ -0,0 +1,101 @@
+package shell
+
+import (
+	"strings"
+
+	"github.com/chzyer/readline/runes"
+)
+
+// Wrap breaks text into lines of at most width columns, at spaces where
+// possible. A width of 0 or less wraps to the output width.
+func (s *Shell) Wrap(text string, width int) string {
+	if width <= 0 {
+		width = s.Width()
+	}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, wrapWords(line, width)...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Truncate shortens text to at most width columns, ending it with an
+// ellipsis when cut. A width of 0 or less truncates to the output width.
+func (s *Shell) Truncate(text string, width int) string {
+	if width <= 0 {
+		width = s.Width()
+	}
+	return truncate(text, width)
+}
+
+// wrapWords wraps a line at spaces, splitting words wider than width
+func wrapWords(line string, width int) []string {
+	if displayWidth(line) <= width {
+		return []string{line}
+	}
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(line) {
+		switch {
+		case current == "":
+			current = word
+		case displayWidth(current)+1+displayWidth(word) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = word
+		}
+		if displayWidth(current) > width {
+			rows := wrapLine(current, width)
+			lines = append(lines, rows[:len(rows)-1]...)
+			current = rows[len(rows)-1]
+		}
+	}
+	return append(lines, current)
+}
+
+// truncate shortens text to width columns, ending it with an ellipsis.
+// Truncated text loses its color sequences.
+func truncate(text string, width int) string {
+	if displayWidth(text) <= width {
+		return text
+	}
+	plain := runes.ColorFilter([]rune(text))
+	for len(plain) > 0 && runes.WidthAll(plain)+1 > width {
+		plain = plain[:len(plain)-1]
+	}
+	return string(plain) + "…"
+}
+
+// wrapLine splits line into rows of at most width columns, keeping color
+// sequences intact
+func wrapLine(line string, width int) []string {
+	if width <= 0 || displayWidth(line) <= width {
+		return []string{line}
+	}
+	var rows []string
+	var row []rune
+	columns := 0
+	text := []rune(line)
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\033' && i+1 < len(text) && text[i+1] == '[' {
+			// Copy the sequence through its final letter
+			j := i + 2
+			for j < len(text) && !('A' <= text[j] && text[j] <= 'Z' || 'a' <= text[j] && text[j] <= 'z') {
+				j++
+			}
+			j = min(j, len(text)-1)
+			row = append(row, text[i:j+1]...)
+			i = j
+			continue
+		}
+		w := runes.Width(text[i])
+		if columns+w > width {
+			rows = append(rows, string(row))
+			row, columns = nil, 0
+		}
+		row = append(row, text[i])
+		columns += w
+	}
+	return append(rows, string(row))
+}
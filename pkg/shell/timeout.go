@@ -0,0 +1,69 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/Necromancerlabs/gocmd2/pkg/shellapi"
+)
+
+// ErrCommandTimeout is returned for a command canceled for running longer
+// than its timeout
+var ErrCommandTimeout = errors.New("command timed out")
+
+// registerTimeoutSettings exposes the command timeout as the
+// command-timeout setting
+func (s *Shell) registerTimeoutSettings() {
+	s.RegisterSetting("command-timeout", "Longest a command may run before it is canceled (a duration such as 30s, 0 for no limit)",
+		time.Duration(s.commandTimeout.Load()).String(),
+		func(value string) error {
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout < 0 {
+				return fmt.Errorf("invalid timeout: %s", value)
+			}
+			s.commandTimeout.Store(int64(timeout))
+			return nil
+		})
+}
+
+// timeoutFor returns how long cmd may run: the timeout annotated on it
+// or on the nearest command it belongs to, otherwise the shell's. Zero
+// means no limit.
+func (s *Shell) timeoutFor(cmd *cobra.Command) time.Duration {
+	for c := cmd; c != nil; c = c.Parent() {
+		value, ok := c.Annotations[shellapi.AnnotationTimeout]
+		if !ok {
+			continue
+		}
+		if timeout, err := time.ParseDuration(value); err == nil && timeout >= 0 {
+			return timeout
+		}
+	}
+	return time.Duration(s.commandTimeout.Load())
+}
+
+// newCommandContext returns the context cmd runs under, canceled once its
+// timeout passes
+func (s *Shell) newCommandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	parent := s.commandContext()
+	if timeout := s.timeoutFor(cmd); timeout > 0 {
+		return context.WithTimeoutCause(parent, timeout, fmt.Errorf("%w after %s", ErrCommandTimeout, timeout))
+	}
+	return context.WithCancel(parent)
+}
+
+// timeoutError reports a command that returned because its context was
+// canceled by a timeout with the timeout, rather than with the bare
+// context error
+func timeoutError(ctx context.Context, err error) error {
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, ErrCommandTimeout) {
+		return cause
+	}
+	return err
+}
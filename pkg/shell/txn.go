@@ -0,0 +1,83 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+)
+
+// undoAction is a rollback step recorded by a command inside a transaction
+type undoAction struct {
+	description string
+	undo        func() error
+}
+
+// transaction collects undo actions between txn begin and commit/abort
+type transaction struct {
+	actions []undoAction
+}
+
+// BeginTransaction starts recording undo actions
+func (s *Shell) BeginTransaction() error {
+	s.txnMutex.Lock()
+	defer s.txnMutex.Unlock()
+
+	if s.txn != nil {
+		return fmt.Errorf("transaction already in progress")
+	}
+	s.txn = &transaction{}
+	return nil
+}
+
+// CommitTransaction ends the transaction and discards its undo actions
+func (s *Shell) CommitTransaction() error {
+	s.txnMutex.Lock()
+	defer s.txnMutex.Unlock()
+
+	if s.txn == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+	s.txn = nil
+	return nil
+}
+
+// AbortTransaction ends the transaction and runs its undo actions in
+// reverse order. Every action is attempted; failures are returned together.
+func (s *Shell) AbortTransaction() error {
+	s.txnMutex.Lock()
+	txn := s.txn
+	s.txn = nil
+	s.txnMutex.Unlock()
+
+	if txn == nil {
+		return fmt.Errorf("no transaction in progress")
+	}
+
+	var errs []error
+	for i := len(txn.actions) - 1; i >= 0; i-- {
+		action := txn.actions[i]
+		s.Info("Undoing: %s", action.description)
+		if err := action.undo(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", action.description, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InTransaction reports whether a transaction is in progress
+func (s *Shell) InTransaction() bool {
+	s.txnMutex.Lock()
+	defer s.txnMutex.Unlock()
+	return s.txn != nil
+}
+
+// RegisterUndo records how to roll back a change made by the current
+// command. It does nothing when no transaction is in progress.
+func (s *Shell) RegisterUndo(description string, undo func() error) {
+	s.txnMutex.Lock()
+	defer s.txnMutex.Unlock()
+
+	if s.txn == nil {
+		return
+	}
+	s.txn.actions = append(s.txn.actions, undoAction{description: description, undo: undo})
+}
@@ -1,7 +1,84 @@
 // Package shellapi defines interfaces for interactions between the shell and modules
 package shellapi
 
-import "github.com/spf13/cobra"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// AnnotationRawArgs marks a command that receives everything after its name
+// as a single untokenized argument. Set it to "true" in the command's
+// Annotations. Flag parsing is disabled for such commands.
+const AnnotationRawArgs = "gocmd2_raw_args"
+
+// AnnotationFileArgs enables file path completion for positional arguments.
+// The value is a comma separated list of zero-based argument positions, or
+// "*" for every position.
+const AnnotationFileArgs = "gocmd2_file_args"
+
+// AnnotationStateKeyArgs enables completion of shared state keys. On a
+// command it takes the same positions as AnnotationFileArgs; on a flag, set
+// with cmd.Flags().SetAnnotation, any value completes the flag's value.
+const AnnotationStateKeyArgs = "gocmd2_state_key_args"
+
+// AnnotationCacheTTL opts a command into result caching. The value is a
+// duration such as "30s"; identical invocations within it replay the earlier
+// output instead of running the command again.
+const AnnotationCacheTTL = "gocmd2_cache_ttl"
+
+// AnnotationTimeout sets how long a command, and its subcommands, may run
+// before its context is canceled, overriding the shell's command timeout.
+// The value is a duration such as "5m"; "0" lets the command run without
+// a limit.
+const AnnotationTimeout = "gocmd2_timeout"
+
+// AnnotationNoHistory keeps invocations of a command, and of its
+// subcommands, out of the history, for commands taking secrets such as
+// `login --password`. Any value enables it.
+const AnnotationNoHistory = "gocmd2_no_history"
+
+// AnnotationPager pages the output of a command when it is taller than the
+// terminal, as a trailing `| more` does for any command. It applies to the
+// annotated command alone, not its subcommands. Any value enables it.
+const AnnotationPager = "gocmd2_pager"
+
+// AnnotationExitStatus sets the exit status a command, and its
+// subcommands, reports when it fails, instead of 1. The value is a number
+// such as "3"; an ExitError returned by the command takes precedence.
+const AnnotationExitStatus = "gocmd2_exit_status"
+
+// StateLastStatus is the session state key holding the exit status of the
+// last command line, an int
+const StateLastStatus = "last_status"
+
+// Capability is a kind of access a module needs, granted by the embedding
+// application when capability checks are on
+type Capability string
+
+const (
+	// CapabilityFilesystem allows ShellAPI calls reading or writing files,
+	// such as TempDir and the state and profile exports
+	CapabilityFilesystem Capability = "filesystem"
+	// CapabilityNetwork is declared by modules talking to other hosts
+	CapabilityNetwork Capability = "network"
+	// CapabilityStateWrite allows changing the session and global state,
+	// settings and features
+	CapabilityStateWrite Capability = "state-write"
+	// CapabilityExec allows running other commands, with ExecuteCommand
+	// and similar calls, and steering the shell: the command tree, hooks
+	// and middleware, modules, jobs and Exit
+	CapabilityExec Capability = "exec"
+)
+
+// ErrCapabilityDenied is returned by ShellAPI calls a module has not been
+// granted the capability for
+var ErrCapabilityDenied = errors.New("capability not granted")
 
 // ShellAPI defines the interface that modules can use to interact with the shell
 type ShellAPI interface {
@@ -11,15 +88,299 @@ type ShellAPI interface {
 	IsModuleEnabled(moduleName string) bool
 	GetModules() []string
 	GetEnabledModules() []string
+	GetModuleMetadata(moduleName string) (ModuleMetadata, bool)
+	DefineModuleGroup(name string, modules ...string)
+	ModuleGroups() []string
+	EnableModuleGroup(name string) error
+	DisableModuleGroup(name string) error
+	UseModuleProfile(name string) error
+	ActiveModuleProfile() string
 	GetRootCmd() *cobra.Command
 	GetModuleCommands() map[string][]*cobra.Command
+	ExecuteCommand(command string) error
+	LastStatus() int
+	ExecuteCommandContext(ctx context.Context, command string) error
+	ExecuteCommandCapture(command string) (stdout, stderr string, err error)
+	ClearCache()
+	RegisterCommands(moduleName string, cmds []*cobra.Command) error
+	UnregisterCommands(moduleName string, cmds []*cobra.Command) error
 
-	// Shell state
-	SetState(key string, value interface{})
+	// TempDir returns a scratch directory for the named module, removed when
+	// the module is disabled or the shell exits
+	TempDir(module string) (string, error)
+
+	// Command history
+	History() []string
+	HistoryRecords() []HistoryRecord
+	ClearHistory() error
+	RegisterHistoryExclusion(pattern string) error
+	SetHistoryInMemory(inMemory bool)
+	HistoryInMemory() bool
+
+	// Completion
+	RegisterCompleter(cmdPath string, fn func(prefix string) []string)
+	CompleteArg(cmdPath string, position int, fn func(prefix string) []string)
+
+	// Shell state, scoped to this shell's session
+	SetState(key string, value interface{}) error
 	GetState(key string) (interface{}, bool)
+	SetStateTTL(key string, value interface{}, ttl time.Duration) error
+	OnStateExpire(key string, fn func(key string, value interface{}))
+	UpdateState(key string, fn func(old interface{}) interface{}) error
+	CompareAndSwapState(key string, old, new interface{}) (bool, error)
+	WatchState(key string, fn func(old, new interface{}))
+	StateKeys(prefix string) []string
+	ListStateKeys() []string
+	DeleteState(key string) (bool, error)
+	ClearState()
+	SnapshotState() map[string]interface{}
+	RestoreState(snapshot map[string]interface{})
+	RegisterStateDecoder(key string, decode func(data []byte) (interface{}, error))
+	StateStats() StateStats
+
+	// Protected state keys can only be written by the module owning them
+	ProtectState(owner, key string) error
+	UnprotectState(owner, key string) error
+	SetOwnedState(owner, key string, value interface{}) error
+	StateOwner(key string) (string, bool)
+
+	// State files share the session state alone as JSON
+	ExportState(path string) error
+	ImportState(path string) error
+
+	// Process-wide state shared by every shell
+	SetGlobalState(key string, value interface{})
+	GetGlobalState(key string) (interface{}, bool)
+	DeleteGlobalState(key string) bool
+	GlobalStateKeys(prefix string) []string
+
+	// Profiles bundle settings and state for moving between machines
+	ExportProfile(path string) error
+	ImportProfile(path string) error
 
 	// UI methods
 	SetPrompt(prompt string)
 	GetPrompt() string
+	SetRightPrompt(text string)
+	GetRightPrompt() string
+	SetStatus(text string)
+	GetStatus() string
+	ReprintBanner()
+	ClearScreen()
+	Interactive() bool
 	PrintAlert(message string)
+	Confirm(question string, defaultYes bool) bool
+	ReadSecret(prompt string) (string, error)
+	Select(label string, options []string) (string, error)
+	MultiSelect(label string, options []string) ([]string, error)
+	ForEachSelected(label string, options []string, command string) error
+	RequestRefresh()
+	NewProgressBar(total int) ProgressBar
+	NewSpinner(label string) Spinner
+
+	// Output width and color support, honoring the width and color settings
+	Width() int
+	Height() int
+	ColorEnabled() bool
+	OnResize(fn func(width, height int))
+	Table(headers []string, rows [][]string)
+	Wrap(text string, width int) string
+	Truncate(text string, width int) string
+
+	// Streams commands should read from and print to instead of the
+	// process's own
+	Stdin() io.Reader
+	Stdout() io.Writer
+	Stderr() io.Writer
+
+	// Leveled output, filtered by the min-output-level setting
+	Info(format string, args ...interface{})
+	Success(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+
+	// Transactions; commands call RegisterUndo to make their changes revertible
+	BeginTransaction() error
+	CommitTransaction() error
+	AbortTransaction() error
+	InTransaction() bool
+	RegisterUndo(description string, undo func() error)
+
+	// Hooks run at each stage of the REPL loop
+	BeforeReadline(fn func())
+	AfterReadline(fn func(line string) string)
+	BeforeExecute(fn func(line string) error)
+	AfterExecute(fn func(line string, err error))
+	Use(middleware ...Middleware)
+
+	// Background jobs; ForegroundJob waits for a job, killing it if ctx is
+	// canceled first
+	Jobs() []Job
+	ForegroundJob(ctx context.Context, id int) error
+	KillJob(id int) error
+
+	// Exit runs the handlers registered with OnExit, last first, and ends
+	// the process
+	OnExit(fn func())
+	Exit(code int)
+
+	// Diagnostics run by the doctor command
+	RegisterHealthCheck(name string, check func() error)
+	RunHealthChecks() []HealthResult
+	ModuleHealth() []ModuleHealth
+
+	// Secret redaction applied to output and history
+	RegisterRedaction(pattern string) error
+	RegisterRedactor(fn func(text string) string)
+	Redact(text string) string
+
+	// Feature flags for shipping experimental commands dark
+	RegisterFeature(name, description string)
+	FeatureEnabled(name string) bool
+	SetFeature(name string, enabled bool)
+	Features() []Feature
+
+	// Runtime settings
+	RegisterSetting(name, description, value string, apply func(value string) error) error
+	SetSetting(name, value string) error
+	GetSetting(name string) (string, bool)
+	GetSettings() []Setting
+}
+
+// HealthResult is the outcome of a single health check
+type HealthResult struct {
+	Name string
+	Err  error
+}
+
+// ModuleHealth is the health of a module reported by the status command.
+// Checked is false for modules without a Health method; disabled modules
+// are not checked.
+type ModuleHealth struct {
+	Name    string
+	Enabled bool
+	Checked bool
+	Err     error
+}
+
+// ExitError is returned by a command failing with a specific exit status.
+// The errors of executed command lines are ExitErrors too, carrying the
+// status the line ended with.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitStatus returns the exit status err stands for: 0 for nil, the code
+// of an ExitError, and 1 for any other error
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 1
+}
+
+// QuoteArg quotes arg, if needed, so the shell reads it back as one
+// argument, for command lines built from values such as file names
+func QuoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t'\"") {
+		return arg
+	}
+	if !strings.Contains(arg, "'") {
+		return "'" + arg + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// CommandHandler runs a command with the arguments and flags following
+// its name
+type CommandHandler func(cmd *cobra.Command, args []string) error
+
+// Middleware wraps the execution of every command, calling next to run
+// it. It can inspect or change the command and its arguments, refuse to
+// run it by returning an error, or act on the error it returned.
+type Middleware func(next CommandHandler) CommandHandler
+
+// Job is a command running in the background, started by ending its line
+// with &
+type Job struct {
+	ID      int
+	Command string
+	Started time.Time
+}
+
+// Feature describes a feature flag toggled with the `feature` command
+type Feature struct {
+	Name        string
+	Description string
+	Enabled     bool
+}
+
+// ModuleMetadata describes a module. Every field is optional.
+type ModuleMetadata struct {
+	Version     string
+	Description string
+	Author      string
+}
+
+// StateStats describes the size of the session state
+type StateStats struct {
+	Keys    int
+	Bytes   int
+	Entries []StateKeyStats
+}
+
+// StateKeyStats is the approximate size of a state key and when it was
+// last changed
+type StateKeyStats struct {
+	Key      string
+	Bytes    int
+	Modified time.Time
+}
+
+// HistoryRecord is an entered command with when it ran, how long it took
+// and its exit status, 0 on success
+type HistoryRecord struct {
+	Line       string
+	Time       time.Time
+	Duration   time.Duration
+	ExitStatus int
+}
+
+// ProgressBar shows how far a long-running command has got. It is drawn on
+// the terminal line while the command runs and printed once, when done, if
+// output is not a terminal.
+type ProgressBar interface {
+	Add(n int)
+	Set(n int)
+	Done()
+}
+
+// Spinner shows that a command of unknown length is still working. If
+// output is not a terminal each label is printed once instead.
+type Spinner interface {
+	SetLabel(label string)
+	Stop()
+}
+
+// Setting describes a runtime shell setting changed with the `set` command
+type Setting struct {
+	Name        string
+	Description string
+	Value       string
 }
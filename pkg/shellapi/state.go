@@ -0,0 +1,54 @@
+package shellapi
+
+import "errors"
+
+// ErrSkipState is returned by MarshalState to leave a value out when state
+// is saved, for values such as open connections that cannot be persisted
+var ErrSkipState = errors.New("state value is not persisted")
+
+// ErrStateProtected is returned when writing a state key another module
+// has protected with ProtectState
+var ErrStateProtected = errors.New("state key is read-only")
+
+// StateMarshaler is implemented by state values that choose how they are
+// saved in profiles and exports. MarshalState returns the JSON to store,
+// or ErrSkipState to leave the value out.
+type StateMarshaler interface {
+	MarshalState() ([]byte, error)
+}
+
+// StateUnmarshaler is implemented by pointers to state value types that
+// restore themselves from what MarshalState stored
+type StateUnmarshaler interface {
+	UnmarshalState(data []byte) error
+}
+
+// GetStateAs returns the shared state value stored under key as a T. It
+// reports false when the key is missing or holds a value of another type,
+// so a module overwriting the key cannot make the caller panic.
+func GetStateAs[T any](s ShellAPI, key string) (T, bool) {
+	value, ok := s.GetState(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	typed, ok := value.(T)
+	return typed, ok
+}
+
+// GetGlobalStateAs is GetStateAs for the process-wide state
+func GetGlobalStateAs[T any](s ShellAPI, key string) (T, bool) {
+	value, ok := s.GetGlobalState(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	typed, ok := value.(T)
+	return typed, ok
+}
+
+// SetTyped stores value under key in the shared state. The type parameter
+// documents at the call site what GetStateAs should ask for.
+func SetTyped[T any](s ShellAPI, key string, value T) error {
+	return s.SetState(key, value)
+}